@@ -11,6 +11,7 @@ import (
 func Body(body io.Reader) Option {
 	return OptionFunc(func(r *Request) {
 		r.body = body
+		r.getBody = nil
 	})
 }
 
@@ -21,6 +22,9 @@ func BodyBytes(body []byte) Option {
 			ContentLength(len(body)),
 			Body(bytes.NewReader(body)),
 		)
+		r.getBody = func() (io.Reader, error) {
+			return bytes.NewReader(body), nil
+		}
 	})
 }
 
@@ -59,5 +63,6 @@ func BodyFormURLEncoded(form url.Values) Option {
 func BodyFunc(f func() (io.Reader, error)) Option {
 	return OptionFunc(func(r *Request) {
 		r.body, r.err = f()
+		r.getBody = nil
 	})
 }
@@ -0,0 +1,430 @@
+package rq
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// レスポンスをstorageにキャッシュする。(RFC 9111のプライベートキャッシュ)
+// キャッシュが新鮮なうちはキャッシュから返し、古くなったらETagやLast-Modifiedで再検証する。
+// クライアントのオプションにすると、クライアントがつくるすべてのリクエストでキャッシュを共有する。
+func Cache(storage CacheStorage) CacheOption {
+	return CacheOption{&httpCache{
+		storage:     storage,
+		maxBodySize: 8 << 20,
+	}}
+}
+
+type CacheOption struct {
+	cache *httpCache
+}
+
+func (option CacheOption) Apply(r *Request) {
+	r.middleware = append(r.middleware, option.cache.middleware)
+}
+
+// キャッシュするレスポンスボディの最大サイズをセットする。
+func (option CacheOption) MaxBodySize(size int64) CacheOption {
+	return CacheOption{&httpCache{
+		storage:     option.cache.storage,
+		maxBodySize: size,
+	}}
+}
+
+// キャッシュの保存先。
+type CacheStorage interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+type httpCache struct {
+	storage     CacheStorage
+	maxBodySize int64
+}
+
+type cacheEntry struct {
+	Status       string
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	Vary         map[string][]string
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+func (c *httpCache) middleware(next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		key := request.URL.String()
+
+		if request.Method != http.MethodGet {
+			response, err := next(request)
+			if err == nil && !isSafeMethod(request.Method) && response.StatusCode < 400 {
+				c.storage.Delete(key)
+			}
+			return response, err
+		}
+
+		if isConditional(request) {
+			return next(request)
+		}
+
+		requestDirectives := parseCacheControl(request.Header)
+		if _, ok := requestDirectives["no-store"]; ok {
+			return next(request)
+		}
+
+		entry := c.load(key, request)
+		if entry != nil && entry.fresh(requestDirectives, time.Now()) {
+			return entry.response(request, time.Now()), nil
+		}
+
+		if _, ok := requestDirectives["only-if-cached"]; ok {
+			// キャッシュにないことは再送しても変わらないので、リトライしない。
+			return &http.Response{
+				Status:     "504 Gateway Timeout",
+				StatusCode: http.StatusGatewayTimeout,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+				Body:       http.NoBody,
+				Request:    request.WithContext(withNoRetry(request.Context())),
+			}, nil
+		}
+
+		outgoing := request
+		if entry != nil && entry.hasValidators() {
+			outgoing = request.Clone(request.Context())
+			for k, v := range entry.validators() {
+				outgoing.Header[k] = v
+			}
+		}
+
+		requestTime := time.Now()
+		response, err := next(outgoing)
+		if err != nil {
+			return nil, err
+		}
+		responseTime := time.Now()
+
+		if outgoing != request && response.StatusCode == http.StatusNotModified {
+			response.Body.Close()
+			entry.update(response.Header, requestTime, responseTime)
+			c.save(key, entry)
+			return entry.response(request, time.Now()), nil
+		}
+
+		if !isCacheable(response) {
+			return response, nil
+		}
+
+		entry = &cacheEntry{
+			Status:       response.Status,
+			StatusCode:   response.StatusCode,
+			Header:       response.Header.Clone(),
+			Vary:         varyValues(request, response),
+			RequestTime:  requestTime,
+			ResponseTime: responseTime,
+		}
+		response.Body = &cacheBody{
+			body:  response.Body,
+			limit: c.maxBodySize,
+			done: func(body []byte) {
+				entry.Body = body
+				c.save(key, entry)
+			},
+		}
+		return response, nil
+	}
+}
+
+func (c *httpCache) load(key string, request *http.Request) *cacheEntry {
+	b, ok := c.storage.Get(key)
+	if !ok {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(b, entry); err != nil {
+		c.storage.Delete(key)
+		return nil
+	}
+	for k, v := range entry.Vary {
+		if k == "*" || strings.Join(request.Header.Values(k), ", ") != strings.Join(v, ", ") {
+			return nil
+		}
+	}
+	return entry
+}
+
+func (c *httpCache) save(key string, entry *cacheEntry) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	c.storage.Set(key, b)
+}
+
+// キャッシュが新鮮かどうか。
+func (entry *cacheEntry) fresh(requestDirectives map[string]string, now time.Time) bool {
+	responseDirectives := parseCacheControl(entry.Header)
+	if _, ok := responseDirectives["no-cache"]; ok {
+		return false
+	}
+	if _, ok := requestDirectives["no-cache"]; ok {
+		return false
+	}
+
+	age := entry.age(now)
+	lifetime := entry.lifetime(responseDirectives)
+
+	if maxAge, ok := directiveSeconds(requestDirectives, "max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := directiveSeconds(requestDirectives, "min-fresh"); ok {
+		age += minFresh
+	}
+
+	if age < lifetime {
+		return true
+	}
+
+	if _, ok := responseDirectives["must-revalidate"]; ok {
+		return false
+	}
+	if v, ok := requestDirectives["max-stale"]; ok {
+		if v == "" {
+			return true
+		}
+		if maxStale, ok := directiveSeconds(requestDirectives, "max-stale"); ok && age-lifetime <= maxStale {
+			return true
+		}
+	}
+	return false
+}
+
+// キャッシュの経過時間を計算する。(RFC 9111 4.2.3)
+func (entry *cacheEntry) age(now time.Time) time.Duration {
+	apparentAge := time.Duration(0)
+	if date, err := http.ParseTime(entry.Header.Get("Date")); err == nil {
+		apparentAge = entry.ResponseTime.Sub(date)
+		if apparentAge < 0 {
+			apparentAge = 0
+		}
+	}
+
+	ageValue := time.Duration(0)
+	if seconds, err := strconv.Atoi(entry.Header.Get("Age")); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	correctedAgeValue := ageValue + entry.ResponseTime.Sub(entry.RequestTime)
+
+	correctedInitialAge := apparentAge
+	if correctedAgeValue > correctedInitialAge {
+		correctedInitialAge = correctedAgeValue
+	}
+
+	return correctedInitialAge + now.Sub(entry.ResponseTime)
+}
+
+// キャッシュの有効期間を計算する。(RFC 9111 4.2.1)
+func (entry *cacheEntry) lifetime(responseDirectives map[string]string) time.Duration {
+	if maxAge, ok := directiveSeconds(responseDirectives, "max-age"); ok {
+		return maxAge
+	}
+
+	date, err := http.ParseTime(entry.Header.Get("Date"))
+	if err != nil {
+		date = entry.ResponseTime
+	}
+
+	if v := entry.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	if lastModified, err := http.ParseTime(entry.Header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+		return date.Sub(lastModified) / 10
+	}
+
+	return 0
+}
+
+func (entry *cacheEntry) hasValidators() bool {
+	return entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != ""
+}
+
+// 再検証のための条件付きリクエストのヘッダをつくる。
+func (entry *cacheEntry) validators() http.Header {
+	r := NewRequest(http.MethodGet, "")
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		if strings.HasPrefix(etag, "W/") {
+			r.header.Set("If-None-Match", etag)
+		} else {
+			r.With(IfNoneMatch(strings.Trim(etag, `"`)))
+		}
+	}
+	if lastModified, err := http.ParseTime(entry.Header.Get("Last-Modified")); err == nil {
+		r.With(IfModifiedSince(lastModified))
+	}
+	return r.header
+}
+
+// 304 Not Modifiedのレスポンスでキャッシュを更新する。
+func (entry *cacheEntry) update(header http.Header, requestTime time.Time, responseTime time.Time) {
+	for k, v := range header {
+		if k == "Content-Length" {
+			continue
+		}
+		entry.Header[k] = v
+	}
+	entry.RequestTime = requestTime
+	entry.ResponseTime = responseTime
+}
+
+func (entry *cacheEntry) response(request *http.Request, now time.Time) *http.Response {
+	header := entry.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(entry.age(now).Seconds())))
+	return &http.Response{
+		Status:        entry.Status,
+		StatusCode:    entry.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       request,
+	}
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func isConditional(request *http.Request) bool {
+	for _, k := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"} {
+		if request.Header.Get(k) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isCacheable(response *http.Response) bool {
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+	default:
+		return false
+	}
+
+	directives := parseCacheControl(response.Header)
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	for _, v := range response.Header.Values("Vary") {
+		if strings.TrimSpace(v) == "*" {
+			return false
+		}
+	}
+
+	if _, ok := directives["max-age"]; ok {
+		return true
+	}
+	if _, ok := directives["public"]; ok {
+		return true
+	}
+	if _, ok := directives["private"]; ok {
+		return true
+	}
+	for _, k := range []string{"Expires", "ETag", "Last-Modified"} {
+		if response.Header.Get(k) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func varyValues(request *http.Request, response *http.Response) map[string][]string {
+	vary := map[string][]string{}
+	for _, v := range response.Header.Values("Vary") {
+		for _, k := range strings.Split(v, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if k != "" {
+				vary[k] = request.Header.Values(k)
+			}
+		}
+	}
+	return vary
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, v := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(directive, "=")
+			directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return directives
+}
+
+func directiveSeconds(directives map[string]string, name string) (time.Duration, bool) {
+	v, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// 読み込んだレスポンスボディをためておき、最後まで読み込んだらdoneを呼ぶ。
+type cacheBody struct {
+	body  io.ReadCloser
+	buf   bytes.Buffer
+	limit int64
+	done  func([]byte)
+}
+
+func (c *cacheBody) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	if c.done != nil {
+		if int64(c.buf.Len()+n) > c.limit {
+			c.done = nil
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && c.done != nil {
+		c.done(c.buf.Bytes())
+		c.done = nil
+	}
+	return n, err
+}
+
+func (c *cacheBody) Close() error {
+	return c.body.Close()
+}
@@ -0,0 +1,118 @@
+package rq
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// メモリにLRUでキャッシュを保存するストレージをつくる。
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		list:       list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+type MemoryCache struct {
+	maxEntries int
+
+	mu    sync.Mutex
+	list  *list.List
+	items map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	value []byte
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.list.MoveToFront(e)
+	return e.Value.(*memoryCacheItem).value, true
+}
+
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*memoryCacheItem).value = value
+		c.list.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.list.PushFront(&memoryCacheItem{key: key, value: value})
+	for c.maxEntries > 0 && c.list.Len() > c.maxEntries {
+		e := c.list.Back()
+		c.list.Remove(e)
+		delete(c.items, e.Value.(*memoryCacheItem).key)
+	}
+}
+
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.list.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// ディレクトリにファイルとしてキャッシュを保存するストレージをつくる。
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{dir: dir}
+}
+
+type DiskCache struct {
+	dir string
+}
+
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+func (c *DiskCache) Set(key string, value []byte) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return
+	}
+	f, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+func (c *DiskCache) Delete(key string) {
+	os.Remove(c.path(key))
+}
+
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,195 @@
+package rq
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// サーキットブレーカーが開いているときのエラー。
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// サーキットブレーカーの状態。
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// ホストごとのサーキットブレーカーをセットする。
+// threshold回連続して失敗すると開いてErrCircuitOpenを返し、cooldown経過後に半開になって1件だけリクエストを通す。
+// クライアントのオプションにすると、クライアントがつくるすべてのリクエストで状態を共有する。
+func CircuitBreaker(threshold int, cooldown time.Duration) CircuitBreakerOption {
+	return CircuitBreakerOption{&circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		errs: []error{
+			ErrInternalServerError,
+			ErrBadGateway,
+			ErrServiceUnavailable,
+			ErrGatewayTimeout,
+		},
+		circuits: map[string]*circuit{},
+	}}
+}
+
+type CircuitBreakerOption struct {
+	breaker *circuitBreaker
+}
+
+func (option CircuitBreakerOption) Apply(r *Request) {
+	r.middleware = append(r.middleware, option.breaker.middleware)
+}
+
+// 失敗とみなすステータスコードのエラーをセットする。
+func (option CircuitBreakerOption) On(err ...error) CircuitBreakerOption {
+	breaker := option.breaker.clone()
+	breaker.errs = append([]error{}, err...)
+	return CircuitBreakerOption{breaker}
+}
+
+// 状態が変わったときのコールバックをセットする。
+func (option CircuitBreakerOption) OnStateChange(f func(host string, from CircuitState, to CircuitState)) CircuitBreakerOption {
+	breaker := option.breaker.clone()
+	breaker.onStateChange = f
+	return CircuitBreakerOption{breaker}
+}
+
+type circuitBreaker struct {
+	threshold     int
+	cooldown      time.Duration
+	errs          []error
+	onStateChange func(string, CircuitState, CircuitState)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// 設定だけをコピーした、状態をもたないサーキットブレーカーを返す。
+func (cb *circuitBreaker) clone() *circuitBreaker {
+	return &circuitBreaker{
+		threshold:     cb.threshold,
+		cooldown:      cb.cooldown,
+		errs:          cb.errs,
+		onStateChange: cb.onStateChange,
+		circuits:      map[string]*circuit{},
+	}
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (cb *circuitBreaker) middleware(next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		host := request.URL.Host
+		if err := cb.allow(host); err != nil {
+			if request.Body != nil {
+				request.Body.Close()
+			}
+			return nil, err
+		}
+
+		response, err := next(request)
+		switch {
+		case request.Context().Err() != nil:
+			cb.release(host)
+		case err != nil:
+			cb.record(host, false)
+		default:
+			cb.record(host, !cb.isFailure(response.StatusCode))
+		}
+		return response, err
+	}
+}
+
+func (cb *circuitBreaker) isFailure(statusCode int) bool {
+	for _, e := range cb.errs {
+		if errors.Is(statusCodeError(statusCode), e) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cb *circuitBreaker) allow(host string) error {
+	cb.mu.Lock()
+	c, ok := cb.circuits[host]
+	if !ok {
+		c = &circuit{state: CircuitClosed}
+		cb.circuits[host] = c
+	}
+
+	from := c.state
+	switch c.state {
+	case CircuitOpen:
+		if time.Since(c.openedAt) < cb.cooldown {
+			cb.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		c.state = CircuitHalfOpen
+		c.probing = true
+	case CircuitHalfOpen:
+		if c.probing {
+			cb.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		c.probing = true
+	}
+	to := c.state
+	cb.mu.Unlock()
+
+	cb.notify(host, from, to)
+	return nil
+}
+
+func (cb *circuitBreaker) record(host string, success bool) {
+	cb.mu.Lock()
+	c := cb.circuits[host]
+	from := c.state
+	c.probing = false
+	if success {
+		c.state = CircuitClosed
+		c.failures = 0
+	} else {
+		c.failures++
+		if c.state == CircuitHalfOpen || c.failures >= cb.threshold {
+			c.state = CircuitOpen
+			c.openedAt = time.Now()
+		}
+	}
+	to := c.state
+	cb.mu.Unlock()
+
+	cb.notify(host, from, to)
+}
+
+func (cb *circuitBreaker) release(host string) {
+	cb.mu.Lock()
+	cb.circuits[host].probing = false
+	cb.mu.Unlock()
+}
+
+func (cb *circuitBreaker) notify(host string, from CircuitState, to CircuitState) {
+	if from != to && cb.onStateChange != nil {
+		cb.onStateChange(host, from, to)
+	}
+}
@@ -0,0 +1,217 @@
+package rq
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// リクエストボディが一度しか読めないときのエラー。
+var ErrBodyNotReplayable = errors.New("request body cannot be read without consuming it")
+
+// リクエストと同じ内容のcurlコマンドを返す。PreHookを実行したあとのリクエストを使う。
+// redactに指定したヘッダとURLクエリの値は伏せる。
+// リクエストボディが再送できないときは、ボディを読み込まずに--data-binary @-にしてErrBodyNotReplayableを返す。
+// BodyMultipartのボディは-Fにして、ファイルは読み込まずにパスを渡す。
+func (r *Request) Curl(redact ...string) (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+
+	request, err := r.build(1, true)
+	if err != nil {
+		return "", err
+	}
+	if request.Body != nil {
+		defer request.Body.Close()
+	}
+
+	return curlCommand(request, r.curlBody, r.redactor, redact)
+}
+
+// リクエストを実行するときに、リクエストと同じ内容のcurlコマンドを出力する。
+// redactに指定したヘッダとURLクエリの値は伏せる。
+func CurlLog(w io.Writer, redact ...string) Option {
+	return OptionFunc(func(r *Request) {
+		r.With(PreHook(func(request *http.Request) error {
+			command, _ := curlCommand(request, r.curlBody, r.redactor, redact)
+			fmt.Fprintln(w, command)
+			return nil
+		}))
+	})
+}
+
+// リクエストボディを、送信するボディを消費せずに返す。
+// 再送できないボディは、multipart/form-dataのパートの一覧だけを参照できる。
+func (r *Request) curlBody() (io.Reader, error) {
+	if r.getBody != nil {
+		return r.getBody()
+	}
+	if body, ok := r.body.(*multipartBody); ok {
+		return body, nil
+	}
+	return nil, ErrBodyNotReplayable
+}
+
+// メモリ上にあるボディだけを読み込んで--data-binaryにする。
+// multipart/form-dataは-Fにして、ファイルは読み込まずにパスを渡す。そのほかのストリーミングするボディは@-にする。
+func curlCommand(request *http.Request, getBody func() (io.Reader, error), redactor *redactor, redact []string) (string, error) {
+	if len(redact) > 0 {
+		redactor = redactor.clone()
+		redactor.headers = append(redactor.headers, redact...)
+		redactor.queries = append(redactor.queries, redact...)
+	}
+
+	args := []string{"curl"}
+
+	hasBody := request.Body != nil && request.Body != http.NoBody
+
+	var body io.Reader
+	var err error
+	if hasBody {
+		body, err = getBody()
+	}
+	_, isMultipart := body.(*multipartBody)
+
+	switch {
+	case request.Method == http.MethodGet && !hasBody:
+	case request.Method == http.MethodHead:
+		args = append(args, "--head")
+	default:
+		args = append(args, "-X", shellQuote(request.Method))
+	}
+
+	args = append(args, shellQuote(redactor.url(request.URL)))
+
+	if request.Host != "" && request.Host != request.URL.Host {
+		args = append(args, "-H", shellQuote("Host: "+request.Host))
+	}
+
+	keys := make([]string, 0, len(request.Header))
+	for k := range request.Header {
+		// -Fのときはcurlがboundaryを決めるので、Content-Typeもcurlにまかせる。
+		if k != "Content-Length" && !(isMultipart && k == "Content-Type") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range request.Header[k] {
+			if redactor.isHeader(k) {
+				v = redacted
+			}
+			args = append(args, "-H", shellQuote(k+": "+v))
+		}
+	}
+
+	if hasBody {
+		switch b := body.(type) {
+		case *multipartBody:
+			var form []string
+			form, err = curlForm(b.parts, redactor)
+			args = append(args, form...)
+		case *bytes.Reader:
+			data, _ := io.ReadAll(b)
+			data = redactor.body(request.Header.Get("Content-Type"), data)
+			args = append(args, "--data-binary", shellQuote(string(data)))
+		default:
+			if c, ok := body.(io.Closer); ok {
+				c.Close()
+			}
+			args = append(args, "--data-binary", "@-")
+		}
+	}
+
+	return strings.Join(args, " "), err
+}
+
+// multipart/form-dataのパートを-Fにする。io.Readerのパートは中身を読めないので@-にしてErrBodyNotReplayableを返す。
+// typeはパラメータに;をふくむことがあるので最後に置く。
+func curlForm(parts []multipartPart, redactor *redactor) ([]string, error) {
+	args := []string{}
+	var err error
+	for _, part := range parts {
+		switch {
+		case part.filename == "":
+			value := part.value
+			if redactor.isQuery(part.name) {
+				value = redacted
+			}
+			// 値が@や<ではじまるときや;をふくむときは、-Fだとファイルやオプションとして解釈されてしまう。
+			if strings.HasPrefix(value, "@") || strings.HasPrefix(value, "<") || strings.Contains(value, ";") {
+				args = append(args, "--form-string", shellQuote(part.name+"="+value))
+			} else {
+				args = append(args, "-F", shellQuote(part.name+"="+value))
+			}
+		case part.path != "":
+			args = append(args, "-F", shellQuote(part.name+"=@"+curlFormQuote(part.path)+";type="+part.contentType))
+		default:
+			args = append(args, "-F", shellQuote(part.name+"=@-;filename="+curlFormQuote(part.filename)+";type="+part.contentType))
+			err = ErrBodyNotReplayable
+		}
+	}
+	return args, err
+}
+
+// -Fの値で区切り文字をふくむときはダブルクォートでかこむ。
+func curlFormQuote(s string) string {
+	if strings.ContainsAny(s, `;,"`) {
+		return `"` + escapeQuotes(s) + `"`
+	}
+	return s
+}
+
+func containsFold(s []string, v string) bool {
+	for i := range s {
+		if strings.EqualFold(s[i], v) {
+			return true
+		}
+	}
+	return false
+}
+
+// シェルで安全に使えるようにクォートする。
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	plain := true
+	printable := utf8.ValidString(s)
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_./:=@,+%", c)) {
+			plain = false
+		}
+		if c < 0x20 && c != '\n' && c != '\t' || c == 0x7f {
+			printable = false
+		}
+	}
+	if plain {
+		return s
+	}
+	if printable {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+
+	b := strings.Builder{}
+	b.WriteString("$'")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\'':
+			b.WriteString(`\'`)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
@@ -0,0 +1,318 @@
+package rq
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Digest認証(RFC 7616)でリクエストする。
+// ステータスコード401のときはWWW-Authenticateヘッダのチャレンジに応答して再送する。
+// アルゴリズムはMD5、SHA-256と、それぞれの-sess版に対応し、qopはauthだけに対応する。
+// クライアントのオプションにすると、受け取ったnonceをホストごとに覚えて、ncを増やしながら以降のリクエストで使いまわす。
+func AuthorizationDigest(username string, password string) Option {
+	d := &digestAuth{username: username, password: password, sessions: map[string]*digestSession{}}
+	return OptionFunc(func(r *Request) {
+		r.middleware = append(r.middleware, d.middleware)
+	})
+}
+
+type digestAuth struct {
+	username string
+	password string
+
+	mu       sync.Mutex
+	sessions map[string]*digestSession // ホストごとのチャレンジ
+}
+
+type digestSession struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	nc        uint32
+}
+
+func (d *digestAuth) middleware(next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		replayable := request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+		retry := request
+		if replayable {
+			retry = request.Clone(request.Context())
+		}
+
+		host := request.URL.Host
+		if authorization, ok := d.authorize(host, request); ok {
+			request.Header.Set("Authorization", authorization)
+		}
+
+		response, err := next(request)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusUnauthorized {
+			d.update(host, response.Header.Get("Authentication-Info"))
+			return response, nil
+		}
+		if !replayable || !d.challenge(host, response.Header.Values("WWW-Authenticate")) {
+			return response, nil
+		}
+
+		authorization, ok := d.authorize(host, retry)
+		if !ok {
+			return response, nil
+		}
+		if request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return response, nil
+			}
+			retry.Body = body
+		}
+		response.Body.Close()
+
+		retry.Header.Set("Authorization", authorization)
+		response, err = next(retry)
+		if err == nil {
+			d.update(host, response.Header.Get("Authentication-Info"))
+		}
+		return response, err
+	}
+}
+
+// WWW-Authenticateヘッダから対応しているDigestのチャレンジを選んで覚える。
+func (d *digestAuth) challenge(host string, values []string) bool {
+	var selected *digestSession
+	for _, c := range parseChallenges(values) {
+		if !strings.EqualFold(c.scheme, "Digest") || c.params["nonce"] == "" {
+			continue
+		}
+
+		algorithm := c.params["algorithm"]
+		if algorithm == "" {
+			algorithm = "MD5"
+		}
+		if digestHash(algorithm) == nil {
+			continue
+		}
+
+		qop := ""
+		if c.params["qop"] != "" {
+			for _, q := range strings.Split(c.params["qop"], ",") {
+				if strings.EqualFold(strings.TrimSpace(q), "auth") {
+					qop = "auth"
+				}
+			}
+			if qop == "" {
+				continue
+			}
+		}
+
+		session := &digestSession{
+			realm:     c.params["realm"],
+			nonce:     c.params["nonce"],
+			opaque:    c.params["opaque"],
+			algorithm: algorithm,
+			qop:       qop,
+		}
+		if selected == nil || digestStrength(session.algorithm) > digestStrength(selected.algorithm) {
+			selected = session
+		}
+	}
+	if selected == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	d.sessions[host] = selected
+	d.mu.Unlock()
+	return true
+}
+
+// Authentication-Infoヘッダのnextnonceで次に使うnonceを更新する。
+func (d *digestAuth) update(host string, info string) {
+	if info == "" {
+		return
+	}
+	nextNonce := ""
+	for _, c := range parseChallenges([]string{"Digest " + info}) {
+		nextNonce = c.params["nextnonce"]
+	}
+	if nextNonce == "" {
+		return
+	}
+
+	d.mu.Lock()
+	if session, ok := d.sessions[host]; ok && session.nonce != nextNonce {
+		session.nonce = nextNonce
+		session.nc = 0
+	}
+	d.mu.Unlock()
+}
+
+// 覚えているチャレンジからAuthorizationヘッダの値をつくる。
+func (d *digestAuth) authorize(host string, request *http.Request) (string, bool) {
+	d.mu.Lock()
+	session, ok := d.sessions[host]
+	if !ok {
+		d.mu.Unlock()
+		return "", false
+	}
+	session.nc++
+	s := *session
+	d.mu.Unlock()
+
+	h := func(data string) string {
+		hash := digestHash(s.algorithm)
+		hash.Write([]byte(data))
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+
+	uri := request.URL.RequestURI()
+	nc := fmt.Sprintf("%08x", s.nc)
+	cnonce := randomHex(16)
+
+	ha1 := h(d.username + ":" + s.realm + ":" + d.password)
+	if strings.HasSuffix(strings.ToLower(s.algorithm), "-sess") {
+		ha1 = h(ha1 + ":" + s.nonce + ":" + cnonce)
+	}
+	ha2 := h(request.Method + ":" + uri)
+
+	response := ""
+	if s.qop == "" {
+		response = h(ha1 + ":" + s.nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + s.nonce + ":" + nc + ":" + cnonce + ":" + s.qop + ":" + ha2)
+	}
+
+	params := []string{
+		"username=" + quote(d.username),
+		"realm=" + quote(s.realm),
+		"uri=" + quote(uri),
+		"algorithm=" + s.algorithm,
+		"nonce=" + quote(s.nonce),
+	}
+	if s.qop != "" {
+		params = append(params, "nc="+nc, "cnonce="+quote(cnonce), "qop="+s.qop)
+	}
+	params = append(params, "response="+quote(response))
+	if s.opaque != "" {
+		params = append(params, "opaque="+quote(s.opaque))
+	}
+	return "Digest " + strings.Join(params, ", "), true
+}
+
+func digestHash(algorithm string) hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case "MD5", "MD5-SESS":
+		return md5.New()
+	case "SHA-256", "SHA-256-SESS":
+		return sha256.New()
+	}
+	return nil
+}
+
+func digestStrength(algorithm string) int {
+	if strings.HasPrefix(strings.ToUpper(algorithm), "SHA-256") {
+		return 2
+	}
+	return 1
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// 認証のチャレンジ。
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// WWW-Authenticateヘッダをチャレンジに分ける。
+// ひとつのヘッダに"Digest realm="a", nonce="b", Basic realm="c""のように複数のチャレンジがあってもよい。
+func parseChallenges(values []string) []challenge {
+	challenges := []challenge{}
+	for _, value := range values {
+		p := &challengeParser{s: value}
+		for {
+			p.skip(" \t,")
+			scheme := p.token()
+			if scheme == "" {
+				break
+			}
+			c := challenge{scheme: scheme, params: map[string]string{}}
+			for {
+				start := p.i
+				p.skip(" \t,")
+				key := p.token()
+				p.skip(" \t")
+				if key == "" || !p.consume('=') {
+					p.i = start
+					break
+				}
+				p.skip(" \t")
+				c.params[strings.ToLower(key)] = p.value()
+			}
+			challenges = append(challenges, c)
+		}
+	}
+	return challenges
+}
+
+type challengeParser struct {
+	s string
+	i int
+}
+
+func (p *challengeParser) skip(chars string) {
+	for p.i < len(p.s) && strings.IndexByte(chars, p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *challengeParser) consume(c byte) bool {
+	if p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// RFC 9110のtokenを読み込む。
+func (p *challengeParser) token() string {
+	start := p.i
+	for p.i < len(p.s) && (strings.IndexByte("!#$%&'*+-.^_`|~", p.s[p.i]) >= 0 ||
+		'0' <= p.s[p.i] && p.s[p.i] <= '9' || 'a' <= p.s[p.i] && p.s[p.i] <= 'z' || 'A' <= p.s[p.i] && p.s[p.i] <= 'Z') {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// tokenかquoted-stringを読み込む。
+func (p *challengeParser) value() string {
+	if !p.consume('"') {
+		return p.token()
+	}
+	b := strings.Builder{}
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '"':
+			return b.String()
+		case c == '\\' && p.i < len(p.s):
+			b.WriteByte(p.s[p.i])
+			p.i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -77,15 +77,46 @@ func ErrBodyLimit(limit int64) Option {
 	})
 }
 
-func responseError(response *http.Response, limit int64, err error) error {
-	body, _ := io.ReadAll(io.LimitReader(response.Body, limit))
+func (r *Request) responseError(response *http.Response, err error) error {
+	var body []byte
+	var payload any
+	if r.errPayload != nil {
+		// Payloadはボディ全体からパースして、Bodyだけをほかのエラーと同じくErrBodyLimitまでにする。
+		full, _ := io.ReadAll(response.Body)
+		if len(full) > 0 {
+			payload, _ = r.errPayload(full)
+		}
+		body = full
+		if limit := r.errBodyLimit; int64(len(body)) > limit {
+			if limit < 0 {
+				limit = 0
+			}
+			body = append([]byte{}, body[:limit]...)
+		}
+	} else {
+		body, _ = io.ReadAll(io.LimitReader(response.Body, r.errBodyLimit))
+	}
+	requestID := ""
+	if r.requestIDHeader != "" {
+		requestID = response.Request.Header.Get(r.requestIDHeader)
+	}
+	var timing *Timing
+	if recorder, ok := response.Request.Context().Value(timingKey{}).(*timingRecorder); ok {
+		snapshot := recorder.snapshot()
+		timing = &snapshot
+	}
 	return &ResponseError{
 		Method:     response.Request.Method,
 		URL:        response.Request.URL,
 		StatusCode: response.StatusCode,
 		Header:     response.Header,
 		Body:       body,
+		Payload:    payload,
+		Problem:    parseProblem(response.Header.Get("Content-Type"), body),
+		Timing:     timing,
+		RequestID:  requestID,
 		err:        err,
+		redactor:   r.redactor,
 	}
 }
 
@@ -95,7 +126,12 @@ type ResponseError struct {
 	StatusCode int
 	Header     http.Header
 	Body       []byte
+	Payload    any      // JSONOrErrorでパースしたレスポンスボディ。
+	Problem    *Problem // Content-Typeがapplication/problem+jsonかapplication/problem+xmlのときにパースしたレスポンスボディ。
+	Timing     *Timing  // Timingsオプションをセットしたときの、エラーになった時点の所要時間。
+	RequestID  string   // Propagateオプションで送信したリクエストID。
 	err        error
+	redactor   *redactor
 }
 
 func (err *ResponseError) Error() string {
@@ -106,23 +142,49 @@ func (err *ResponseError) Error() string {
 		method = err.Method[:1] + strings.ToLower(err.Method)[1:]
 	}
 
-	url := err.URL.String()
-	if _, ok := err.URL.User.Password(); ok {
-		url = strings.Replace(url, err.URL.User.String()+"@", err.URL.User.Username()+":***@", 1)
+	redactor := err.redactor
+	if redactor == nil {
+		redactor = defaultRedactor
 	}
+	url := redactor.url(err.URL)
 
-	return fmt.Sprintf("%s %q: %s", method, url, err.err.Error())
+	msg := fmt.Sprintf("%s %q: %s", method, url, err.err.Error())
+	if err.Problem != nil {
+		msg += " (" + err.Problem.Error() + ")"
+	}
+	if err.RequestID != "" {
+		msg += " (request id: " + err.RequestID + ")"
+	}
+	return msg
 }
 
 func (err *ResponseError) Unwrap() error {
 	return err.err
 }
 
+func (err *ResponseError) As(target any) bool {
+	if p, ok := target.(**Problem); ok && err.Problem != nil {
+		*p = err.Problem
+		return true
+	}
+	return false
+}
+
 func AsResponseError(err error) (*ResponseError, bool) {
 	e, ok := err.(*ResponseError)
 	return e, ok
 }
 
+// ResponseErrorのPayloadをE型で取り出す。
+func ErrorPayload[E any](err error) (E, bool) {
+	if e, ok := AsResponseError(err); ok {
+		payload, ok := e.Payload.(E)
+		return payload, ok
+	}
+	var zero E
+	return zero, false
+}
+
 func MapResponseError(err error, fn func(*ResponseError) error) error {
 	e, ok := err.(*ResponseError)
 	if ok {
@@ -0,0 +1,154 @@
+package rq
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server-Sent Eventsのイベント。
+type Event struct {
+	ID    string        // idフィールド。最後に受信したイベントIDが入る。
+	Type  string        // eventフィールド。省略されたときは"message"。
+	Data  string        // dataフィールド。複数行のときは改行で結合される。
+	Retry time.Duration // retryフィールド。指定されていないときは0。
+}
+
+// リクエストを実行してServer-Sent Eventsを受信する。
+// 切断されたときはサーバーが指定した間隔をあけてLast-Event-IDヘッダをつけて再接続する。
+// handlerがエラーを返すか、コンテキストが終了するか、ステータスコード204を受信するまで受信しつづける。
+func (r *Request) FetchEvents(handler func(Event) error) error {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	stream := &eventStream{retry: 3 * time.Second}
+	for {
+		err := r.fetchEvents(ctx, stream, handler)
+		if err != errReconnect {
+			return err
+		}
+		if err := sleep(ctx, stream.retry); err != nil {
+			return err
+		}
+	}
+}
+
+var errReconnect = errors.New("reconnect")
+
+type eventStream struct {
+	lastEventID string
+	retry       time.Duration
+}
+
+func (r *Request) fetchEvents(ctx context.Context, stream *eventStream, handler func(Event) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conn := *r
+	conn.ctx = ctx
+	conn.header = r.header.Clone()
+	conn.header.Set("Accept", "text/event-stream")
+	conn.header.Set("Cache-Control", "no-cache")
+	if stream.lastEventID != "" {
+		conn.header.Set("Last-Event-ID", stream.lastEventID)
+	}
+
+	response, err := conn.Do()
+	if err != nil {
+		if ctx.Err() != nil || !conn.replayable() {
+			return err
+		}
+		return errReconnect
+	}
+	defer func() {
+		cancel()
+		response.Body.Close()
+	}()
+
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if response.StatusCode >= 400 {
+		return r.responseError(response, statusCodeError(response.StatusCode))
+	}
+
+	err = stream.read(response.Body, handler)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !conn.replayable() {
+		return io.ErrUnexpectedEOF
+	}
+	return errReconnect
+}
+
+// イベントを読み込んでhandlerに渡す。接続が切れたときはnilを返す。
+func (stream *eventStream) read(body io.Reader, handler func(Event) error) error {
+	reader := bufio.NewReader(body)
+
+	eventType := ""
+	data := strings.Builder{}
+	retry := time.Duration(0)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if line == "" {
+			if data.Len() > 0 {
+				event := Event{
+					ID:    stream.lastEventID,
+					Type:  eventType,
+					Data:  strings.TrimSuffix(data.String(), "\n"),
+					Retry: retry,
+				}
+				if event.Type == "" {
+					event.Type = "message"
+				}
+				if err := handler(event); err != nil {
+					return err
+				}
+			}
+			eventType = ""
+			data.Reset()
+			retry = 0
+			continue
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteString("\n")
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				stream.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				retry = time.Duration(ms) * time.Millisecond
+				stream.retry = retry
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package rq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// リクエストをネットワークを使わずにhttp.Handlerで直接処理する。
+func Handler(h http.Handler) Option {
+	return HTTPClient(&http.Client{Transport: HandlerTransport(h)})
+}
+
+// リクエストをhttp.Handlerで直接処理するhttp.RoundTripperをつくる。
+func HandlerTransport(h http.Handler) http.RoundTripper {
+	return handlerTransport{h}
+}
+
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(request.Context())
+
+	serverRequest := request.Clone(ctx)
+	serverRequest.RequestURI = request.URL.RequestURI()
+	serverRequest.RemoteAddr = "192.0.2.1:1234"
+	serverRequest.Proto = "HTTP/1.1"
+	serverRequest.ProtoMajor = 1
+	serverRequest.ProtoMinor = 1
+	if serverRequest.Host == "" {
+		serverRequest.Host = request.URL.Host
+	}
+	if serverRequest.Body == nil {
+		serverRequest.Body = http.NoBody
+	}
+
+	pr, pw := io.Pipe()
+	w := &handlerResponseWriter{
+		header:  http.Header{},
+		body:    pw,
+		started: make(chan struct{}),
+		request: request,
+		ctx:     ctx,
+		head:    request.Method == http.MethodHead,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var err error
+		defer func() {
+			if v := recover(); v != nil {
+				err = fmt.Errorf("handler panic: %v", v)
+			}
+			// net/httpのサーバーと同じく、ハンドラーが読まなかったリクエストボディも閉じる。
+			serverRequest.Body.Close()
+			pw.CloseWithError(w.finish(err))
+		}()
+		t.handler.ServeHTTP(w, serverRequest)
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			pw.CloseWithError(ctx.Err())
+		case <-done:
+		}
+	}()
+
+	select {
+	case <-w.started:
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	w.mu.Lock()
+	response := w.response
+	err := w.err
+	w.mu.Unlock()
+
+	if response == nil {
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	response.Body = handlerBody{pr, cancel}
+	return response, nil
+}
+
+type handlerResponseWriter struct {
+	header  http.Header
+	body    *io.PipeWriter
+	started chan struct{}
+	request *http.Request
+	ctx     context.Context
+	head    bool
+
+	mu       sync.Mutex
+	response *http.Response
+	err      error
+}
+
+func (w *handlerResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *handlerResponseWriter) WriteHeader(statusCode int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.response != nil {
+		return
+	}
+
+	header := w.header.Clone()
+	trailer := http.Header{}
+	for _, v := range header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				trailer[http.CanonicalHeaderKey(k)] = nil
+			}
+		}
+	}
+	for k := range header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			delete(header, k)
+		}
+	}
+	header.Del("Trailer")
+
+	contentLength := int64(-1)
+	if v := header.Get("Content-Length"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			contentLength = n
+		}
+	}
+
+	w.response = &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Trailer:       trailer,
+		ContentLength: contentLength,
+		Request:       w.request,
+	}
+	close(w.started)
+}
+
+func (w *handlerResponseWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	started := w.response != nil
+	w.mu.Unlock()
+
+	if !started {
+		if w.header.Get("Content-Type") == "" && w.header.Get("Transfer-Encoding") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.head {
+		return len(p), nil
+	}
+	return w.body.Write(p)
+}
+
+func (w *handlerResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+// ハンドラが終了したときにトレーラーをセットする。
+// レスポンスを書きはじめる前にコンテキストが終了していたときは、レスポンスをつくらずにコンテキストのエラーを返す。
+func (w *handlerResponseWriter) finish(err error) error {
+	w.mu.Lock()
+	started := w.response != nil
+	w.mu.Unlock()
+
+	if err == nil && !started && w.ctx.Err() != nil {
+		err = w.ctx.Err()
+	}
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.err = err
+	if w.response == nil {
+		return err
+	}
+	for k := range w.response.Trailer {
+		w.response.Trailer[k] = w.header.Values(k)
+	}
+	for k, v := range w.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			w.response.Trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = v
+		}
+	}
+	return err
+}
+
+type handlerBody struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (b handlerBody) Close() error {
+	b.cancel()
+	return b.PipeReader.Close()
+}
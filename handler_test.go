@@ -0,0 +1,100 @@
+package rq_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thamaji/rq"
+)
+
+func TestHandlerContextCanceledBeforeResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "handler returns on cancel",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+		},
+		{
+			name: "handler ignores cancel",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			response, err := rq.Get("http://example.com/", rq.Handler(tt.handler), rq.Context(ctx)).Do()
+			if err == nil {
+				response.Body.Close()
+				t.Fatalf("Do() returned status %d, want error", response.StatusCode)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Do() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestHandlerResponse(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+
+	response, err := rq.Get("http://example.com/", rq.Handler(handler)).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		t.Errorf("StatusCode = %d, want %d", response.StatusCode, http.StatusCreated)
+	}
+
+	body, err := rq.Get("http://example.com/", rq.Handler(handler)).Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello" {
+		t.Errorf("body = %q, want %q", body, "hello")
+	}
+}
+
+func TestHandlerClosesRequestBody(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	body := &closeRecorder{Reader: strings.NewReader("hello")}
+	err := rq.Post("http://example.com/", rq.Handler(handler), rq.Body(body)).Done()
+	if !errors.Is(err, rq.ErrBadRequest) {
+		t.Fatalf("Done() error = %v, want %v", err, rq.ErrBadRequest)
+	}
+	if !body.closed {
+		t.Error("request body was not closed")
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
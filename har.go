@@ -0,0 +1,514 @@
+package rq
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// リクエストとレスポンスをHAR 1.2形式でwに出力する。
+// Doごとに、リトライを含むすべてのやりとりを1つのHARとして、レスポンスボディを閉じたときに出力する。
+// クライアントのオプションにするとリクエストごとにHARを出力してしまうので、
+// クライアント全体を1つのHARにするときはNewHARRecorderとHARRecorder.WriteToを使う。
+func HAR(w io.Writer) Option {
+	return OptionFunc(func(r *Request) {
+		recorder := NewHARRecorder()
+		recorder.Apply(r)
+		r.aroundHook = append(r.aroundHook, func(ctx context.Context, next func(context.Context) (*http.Response, error)) (*http.Response, error) {
+			response, err := next(ctx)
+			if err != nil {
+				recorder.flush(w)
+				return nil, err
+			}
+
+			once := sync.Once{}
+			finish := func() {
+				once.Do(func() {
+					recorder.flush(w)
+				})
+			}
+			response.Body = &captureBody{body: response.Body, buf: &captureBuffer{}, finish: finish}
+			return response, nil
+		})
+	})
+}
+
+// リクエストとレスポンスをHAR 1.2形式で記録するオプションをつくる。
+// クライアントのオプションにすると、クライアントがつくるすべてのリクエストを記録する。
+func NewHARRecorder() *HARRecorder {
+	return &HARRecorder{limit: 1 << 20}
+}
+
+type HARRecorder struct {
+	limit int64
+
+	mu      sync.Mutex
+	entries []HAREntry
+}
+
+func (h *HARRecorder) Apply(r *Request) {
+	r.middleware = append(r.middleware, func(next doFunc) doFunc {
+		return h.middleware(r.redactor, next)
+	})
+}
+
+// 記録するリクエストボディとレスポンスボディの最大サイズをセットする。
+func (h *HARRecorder) Limit(limit int64) *HARRecorder {
+	h.limit = limit
+	return h
+}
+
+// 記録したエントリーを返す。
+func (h *HARRecorder) Entries() []HAREntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]HAREntry{}, h.entries...)
+}
+
+// 記録したエントリーをHAR 1.2形式でwに出力する。
+func (h *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	return writeHAR(w, h.Entries())
+}
+
+// 記録したエントリーをwに出力して消す。
+func (h *HARRecorder) flush(w io.Writer) {
+	h.mu.Lock()
+	entries := h.entries
+	h.entries = nil
+	h.mu.Unlock()
+	writeHAR(w, entries)
+}
+
+func (h *HARRecorder) add(entry HAREntry) {
+	h.mu.Lock()
+	h.entries = append(h.entries, entry)
+	h.mu.Unlock()
+}
+
+func (h *HARRecorder) middleware(redactor *redactor, next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		var requestBody *captureBuffer
+		if request.Body != nil && request.Body != http.NoBody {
+			requestBody = &captureBuffer{limit: h.limit}
+			request.Body = teeReadCloser{request.Body, requestBody}
+		}
+
+		trace := &harTrace{}
+		request = request.WithContext(httptrace.WithClientTrace(request.Context(), trace.clientTrace()))
+
+		started := time.Now()
+		response, err := next(request)
+		responded := time.Now()
+
+		entry := HAREntry{
+			StartedDateTime: started.Format(time.RFC3339Nano),
+			Request:         newHARRequest(request, requestBody, redactor),
+			Cache:           struct{}{},
+		}
+
+		if err != nil {
+			entry.Timings = trace.timings(started, responded, responded)
+			entry.Time = entry.Timings.total()
+			entry.ServerIPAddress, entry.Connection = trace.conn()
+			entry.Response = HARResponse{
+				Cookies: []HARCookie{},
+				Headers: []HARNameValue{},
+				Content: HARContent{MimeType: "x-unknown"},
+			}
+			entry.Error = err.Error()
+			h.add(entry)
+			return nil, err
+		}
+
+		responseBody := &captureBuffer{limit: h.limit}
+		once := sync.Once{}
+		finish := func() {
+			once.Do(func() {
+				entry.Timings = trace.timings(started, responded, time.Now())
+				entry.Time = entry.Timings.total()
+				entry.ServerIPAddress, entry.Connection = trace.conn()
+				entry.Response = newHARResponse(response, responseBody, redactor)
+				h.add(entry)
+			})
+		}
+		response.Body = &captureBody{body: response.Body, buf: responseBody, finish: finish}
+		return response, nil
+	}
+}
+
+// 試行ひとつぶんの各段階の時刻。リダイレクトしたときは最初の接続の時刻になる。
+type harTrace struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	serverIP     string
+	connection   string
+}
+
+func (t *harTrace) clientTrace() *httptrace.ClientTrace {
+	mark := func(at *time.Time) {
+		t.mu.Lock()
+		if at.IsZero() {
+			*at = time.Now()
+		}
+		t.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { mark(&t.dnsStart) },
+		DNSDone:      func(httptrace.DNSDoneInfo) { mark(&t.dnsDone) },
+		ConnectStart: func(string, string) { mark(&t.connectStart) },
+		ConnectDone: func(network string, addr string, err error) {
+			if err == nil {
+				mark(&t.connectDone)
+			}
+		},
+		TLSHandshakeStart: func() { mark(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { mark(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if !t.gotConn.IsZero() {
+				return
+			}
+			t.gotConn = time.Now()
+			if info.Conn != nil {
+				t.serverIP = info.Conn.RemoteAddr().String()
+				if host, _, err := net.SplitHostPort(t.serverIP); err == nil {
+					t.serverIP = host
+				}
+				// 接続を区別できるように、ローカルのポート番号を接続のIDにする。
+				t.connection = info.Conn.LocalAddr().String()
+				if _, port, err := net.SplitHostPort(t.connection); err == nil {
+					t.connection = port
+				}
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { mark(&t.wroteRequest) },
+		GotFirstResponseByte: func() { mark(&t.firstByte) },
+	}
+}
+
+func (t *harTrace) conn() (string, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.serverIP, t.connection
+}
+
+// startedに送信をはじめて、respondedにレスポンスヘッダを受け取り、finishedにレスポンスボディを読み終えたときのtimingsを返す。
+// 記録していない段階は-1にする。connectはHARの仕様にあわせてsslの時間を含む。
+func (t *harTrace) timings(started time.Time, responded time.Time, finished time.Time) HARTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	since := func(from time.Time, to time.Time) float64 {
+		return milliseconds(to.Sub(from))
+	}
+
+	timings := HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	if !t.dnsStart.IsZero() && !t.dnsDone.IsZero() {
+		timings.DNS = since(t.dnsStart, t.dnsDone)
+	}
+	connectDone := t.connectDone
+	if !t.tlsStart.IsZero() && !t.tlsDone.IsZero() {
+		timings.SSL = since(t.tlsStart, t.tlsDone)
+		connectDone = t.tlsDone
+	}
+	if !t.connectStart.IsZero() && !connectDone.IsZero() {
+		timings.Connect = since(t.connectStart, connectDone)
+	}
+	setup := math.Max(timings.DNS, 0) + math.Max(timings.Connect, 0)
+
+	firstByte := t.firstByte
+	if firstByte.IsZero() {
+		firstByte = responded
+	}
+	timings.Receive = since(firstByte, finished)
+
+	// HandlerTransportのように接続しないときは、送信をはじめてから最初のバイトまでを待ち時間にする。
+	if t.gotConn.IsZero() {
+		timings.Wait = math.Max(since(started, firstByte)-setup, 0)
+		return timings
+	}
+
+	timings.Blocked = math.Max(since(started, t.gotConn)-setup, 0)
+	sent := t.wroteRequest
+	if sent.IsZero() {
+		sent = t.gotConn
+	}
+	timings.Send = since(t.gotConn, sent)
+	timings.Wait = since(sent, firstByte)
+	return timings
+}
+
+func writeHAR(w io.Writer, entries []HAREntry) (int64, error) {
+	if entries == nil {
+		entries = []HAREntry{}
+	}
+	har := HARFile{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "rq", Version: "1.0"},
+		Entries: entries,
+	}}
+	b, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
+
+func newHARRequest(request *http.Request, body *captureBuffer, redactor *redactor) HARRequest {
+	r := HARRequest{
+		Method:      request.Method,
+		URL:         redactor.url(request.URL),
+		HTTPVersion: request.Proto,
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(redactor.header(request.Header)),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+	if !redactor.isHeader("Cookie") {
+		for _, cookie := range request.Cookies() {
+			r.Cookies = append(r.Cookies, HARCookie{Name: cookie.Name, Value: cookie.Value})
+		}
+	}
+	for k, values := range request.URL.Query() {
+		for _, v := range values {
+			if redactor.isQuery(k) {
+				v = redacted
+			}
+			r.QueryString = append(r.QueryString, HARNameValue{Name: k, Value: v})
+		}
+	}
+	if body != nil {
+		r.BodySize = body.size
+		text, encoding := body.content(redactor, request.Header.Get("Content-Type"))
+		r.PostData = &HARPostData{
+			MimeType: request.Header.Get("Content-Type"),
+			Params:   []HARNameValue{},
+			Text:     text,
+			Encoding: encoding,
+		}
+	}
+	return r
+}
+
+func newHARResponse(response *http.Response, body *captureBuffer, redactor *redactor) HARResponse {
+	r := HARResponse{
+		Status:      response.StatusCode,
+		StatusText:  http.StatusText(response.StatusCode),
+		HTTPVersion: response.Proto,
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(redactor.header(response.Header)),
+		RedirectURL: response.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    body.size,
+	}
+	for _, cookie := range response.Cookies() {
+		if redactor.isHeader("Set-Cookie") {
+			break
+		}
+		c := HARCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HTTPOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		}
+		if !cookie.Expires.IsZero() {
+			c.Expires = cookie.Expires.Format(time.RFC3339)
+		}
+		r.Cookies = append(r.Cookies, c)
+	}
+
+	mimeType := response.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = "x-unknown"
+	}
+	text, encoding := body.content(redactor, response.Header.Get("Content-Type"))
+	r.Content = HARContent{
+		Size:     body.size,
+		MimeType: mimeType,
+		Text:     text,
+		Encoding: encoding,
+	}
+	return r
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	headers := []HARNameValue{}
+	for k, values := range header {
+		for _, v := range values {
+			headers = append(headers, HARNameValue{Name: k, Value: v})
+		}
+	}
+	return headers
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// 最大limitバイトまでためておくバッファ。
+type captureBuffer struct {
+	buf   bytes.Buffer
+	size  int64
+	limit int64
+}
+
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	b.size += int64(len(p))
+	if remain := b.limit - int64(b.buf.Len()); remain > 0 {
+		if int64(len(p)) > remain {
+			b.buf.Write(p[:remain])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// テキストなら秘密情報を伏せて、バイナリならbase64で返す。
+func (b *captureBuffer) content(redactor *redactor, contentType string) (string, string) {
+	if utf8.Valid(b.buf.Bytes()) {
+		return string(redactor.body(contentType, b.buf.Bytes())), ""
+	}
+	return base64.StdEncoding.EncodeToString(b.buf.Bytes()), "base64"
+}
+
+type teeReadCloser struct {
+	rc io.ReadCloser
+	w  io.Writer
+}
+
+func (t teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.rc.Read(p)
+	if n > 0 {
+		t.w.Write(p[:n])
+	}
+	return n, err
+}
+
+func (t teeReadCloser) Close() error {
+	return t.rc.Close()
+}
+
+type HARFile struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []HARNameValue `json:"params"`
+	Text     string         `json:"text"`
+	Encoding string         `json:"encoding,omitempty"`
+}
+
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// HAREntry.Timeにする、-1を除いた合計。sslはconnectに含まれるので足さない。
+func (t HARTimings) total() float64 {
+	total := 0.0
+	for _, v := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if v > 0 {
+			total += v
+		}
+	}
+	return total
+}
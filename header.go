@@ -369,7 +369,7 @@ func IfMatch(etagValue ...string) Option {
 
 // If-Modified-Sinceヘッダをセットする。
 func IfModifiedSince(timestamp time.Time) Option {
-	return Header("If-Modified-Since", timestamp.Format(time.RFC1123))
+	return Header("If-Modified-Since", timestamp.UTC().Format(http.TimeFormat))
 }
 
 // If-None-Matchヘッダをセットする。
@@ -391,14 +391,14 @@ func IfRange(timestamp time.Time, etagValue string) Option {
 		if etagValue != "*" {
 			etagValue = "\"" + etagValue + "\""
 		}
-		r.header.Set("If-Range", timestamp.Format(time.RFC1123))
+		r.header.Set("If-Range", timestamp.UTC().Format(http.TimeFormat))
 		r.header.Add("If-Range", etagValue)
 	})
 }
 
 // If-Unmodified-Sinceヘッダをセットする。
 func IfUnmodifiedSince(timestamp time.Time) Option {
-	return Header("If-Unmodified-Since", timestamp.Format(time.RFC1123))
+	return Header("If-Unmodified-Since", timestamp.UTC().Format(http.TimeFormat))
 }
 
 // Originヘッダをセットする。
@@ -1,6 +1,9 @@
 package rq
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 // リクエスト実行直前のフックをセットする。
 func PreHook(hook func(*http.Request) error) Option {
@@ -15,3 +18,11 @@ func PostHook(hook func(*http.Response) error) Option {
 		r.postHook = append(r.postHook, hook)
 	})
 }
+
+// Doの全体をはさむフックをセットする。リトライしてもDoごとに1回だけ呼ばれる。
+// hookはnextに渡したコンテキストでリクエストを実行し、そのレスポンスかエラーを返す。
+func AroundHook(hook func(ctx context.Context, next func(context.Context) (*http.Response, error)) (*http.Response, error)) Option {
+	return OptionFunc(func(r *Request) {
+		r.aroundHook = append(r.aroundHook, hook)
+	})
+}
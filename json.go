@@ -0,0 +1,211 @@
+package rq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// リクエストを実行してレスポンスボディのJSONをT型の値にパースして返す。
+func JSON[T any](r *Request) (T, error) {
+	var v T
+	err := r.FetchJSON(&v)
+	return v, err
+}
+
+// リクエストを実行してレスポンスボディのJSONをT型の値にパースして返す。
+// ステータスコードが400以上のときは、レスポンスボディのJSONをE型の値にパースしてResponseError.Payloadにセットする。
+// E型の値はErrBodyLimitにかかわらずレスポンスボディ全体からパースする。ResponseError.BodyはErrBodyLimitまでになる。
+func JSONOrError[T any, E any](r *Request) (T, error) {
+	r.errPayload = func(body []byte) (any, bool) {
+		var payload E
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, false
+		}
+		return payload, true
+	}
+	var v T
+	err := r.FetchJSON(&v)
+	return v, err
+}
+
+// クライアントでリクエストを実行してレスポンスボディのJSONをT型の値にパースして返す。
+func ClientJSON[T any](c *Client, method string, url string, options ...Option) (T, error) {
+	return JSON[T](c.NewRequest(method, url, options...))
+}
+
+// クライアントでリクエストを実行してレスポンスボディのJSONをT型の値にパースして返す。
+// ステータスコードが400以上のときは、レスポンスボディのJSONをE型の値にパースしてResponseError.Payloadにセットする。
+func ClientJSONOrError[T any, E any](c *Client, method string, url string, options ...Option) (T, error) {
+	return JSONOrError[T, E](c.NewRequest(method, url, options...))
+}
+
+// リクエストを実行してレスポンスボディのJSON Lines(NDJSON)を1行ずつパースしてhandlerに渡す。
+// handlerがエラーを返したときはそこで読み込みをやめてエラーを返す。
+func FetchJSONLines[T any](r *Request, handler func(T) error) error {
+	r.With(Accept("application/x-ndjson", "application/jsonl", "application/json"))
+	body, err := r.Open()
+	if err != nil {
+		return err
+	}
+	err = decodeJSONLines(body, handler)
+	if err1 := body.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func decodeJSONLines[T any](r io.Reader, handler func(T) error) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var v T
+		if err := decoder.Decode(&v); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := handler(v); err != nil {
+			return err
+		}
+	}
+}
+
+// リクエストを実行してレスポンスボディのJSON配列を要素ごとにパースしてhandlerに渡す。
+// 配列全体をメモリに読み込まないので巨大な配列でも扱える。
+func FetchJSONArray[T any](r *Request, handler func(T) error) error {
+	return FetchJSONArrayAt(r, "", handler)
+}
+
+// リクエストを実行してレスポンスボディのJSONポインタ(RFC 6901)が指す配列を要素ごとにパースしてhandlerに渡す。
+func FetchJSONArrayAt[T any](r *Request, pointer string, handler func(T) error) error {
+	r.With(Accept("application/json"))
+	body, err := r.Open()
+	if err != nil {
+		return err
+	}
+	err = decodeJSONArray(body, pointer, handler)
+	if err1 := body.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func decodeJSONArray[T any](r io.Reader, pointer string, handler func(T) error) error {
+	tokens, err := parseJSONPointer(pointer)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r)
+	for _, token := range tokens {
+		found, err := seekJSON(decoder, token)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("json pointer %q: not found", pointer)
+		}
+	}
+
+	if err := expectDelim(decoder, '['); err != nil {
+		return err
+	}
+	for decoder.More() {
+		var v T
+		if err := decoder.Decode(&v); err != nil {
+			return err
+		}
+		if err := handler(v); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, ']')
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("json pointer %q: must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// 現在の値のなかからtokenが指す値の直前まで読み進める。
+func seekJSON(decoder *json.Decoder, token string) (bool, error) {
+	t, err := decoder.Token()
+	if err != nil {
+		return false, err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return false, err
+			}
+			if key == token {
+				return true, nil
+			}
+			if err := skipJSON(decoder); err != nil {
+				return false, err
+			}
+		}
+
+	case json.Delim('['):
+		index, err := strconv.Atoi(token)
+		if err != nil || index < 0 {
+			return false, nil
+		}
+		for i := 0; decoder.More(); i++ {
+			if i == index {
+				return true, nil
+			}
+			if err := skipJSON(decoder); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// 値をひとつ読み飛ばす。
+func skipJSON(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		t, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	t, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("json: expected %s but got %v", delim, t)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+//go:build go1.21
+
+package rq
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// リクエストの開始、レスポンス、エラーをslog.Loggerに構造化ログとして出力する。
+// クライアントのオプションにすると、クライアントがつくるすべてのリクエストを出力する。
+func Logger(logger *slog.Logger) LoggerOption {
+	return LoggerOption{
+		logger:           logger,
+		requestLevel:     slog.LevelDebug,
+		responseLevel:    slog.LevelInfo,
+		clientErrorLevel: slog.LevelWarn,
+		serverErrorLevel: slog.LevelError,
+		errorLevel:       slog.LevelError,
+	}
+}
+
+type LoggerOption struct {
+	logger           *slog.Logger
+	requestLevel     slog.Level
+	responseLevel    slog.Level
+	clientErrorLevel slog.Level
+	serverErrorLevel slog.Level
+	errorLevel       slog.Level
+	headers          []string
+	bodyLimit        int64
+}
+
+func (option LoggerOption) Apply(r *Request) {
+	r.middleware = append(r.middleware, func(next doFunc) doFunc {
+		return option.middleware(r.redactor, next)
+	})
+}
+
+// リクエスト開始のログのレベルをセットする。
+func (option LoggerOption) RequestLevel(level slog.Level) LoggerOption {
+	option.requestLevel = level
+	return option
+}
+
+// ステータスコードが400未満のレスポンスのログのレベルをセットする。
+func (option LoggerOption) ResponseLevel(level slog.Level) LoggerOption {
+	option.responseLevel = level
+	return option
+}
+
+// ステータスコードが400番台のレスポンスのログのレベルをセットする。
+func (option LoggerOption) ClientErrorLevel(level slog.Level) LoggerOption {
+	option.clientErrorLevel = level
+	return option
+}
+
+// ステータスコードが500番台のレスポンスのログのレベルをセットする。
+func (option LoggerOption) ServerErrorLevel(level slog.Level) LoggerOption {
+	option.serverErrorLevel = level
+	return option
+}
+
+// 通信エラーのログのレベルをセットする。
+func (option LoggerOption) ErrorLevel(level slog.Level) LoggerOption {
+	option.errorLevel = level
+	return option
+}
+
+// ログに出力するヘッダをセットする。
+func (option LoggerOption) Headers(key ...string) LoggerOption {
+	option.headers = append(append([]string{}, option.headers...), key...)
+	return option
+}
+
+// リクエストボディとレスポンスボディを最大limitバイトまでログに出力する。
+func (option LoggerOption) Body(limit int64) LoggerOption {
+	option.bodyLimit = limit
+	return option
+}
+
+func (option LoggerOption) middleware(redactor *redactor, next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		ctx := request.Context()
+		attrs := []slog.Attr{
+			slog.String("method", request.Method),
+			slog.String("url", redactor.url(request.URL)),
+			slog.Int("attempt", Attempt(ctx)),
+		}
+
+		var requestBody *captureBuffer
+		if request.Body != nil && request.Body != http.NoBody {
+			requestBody = &captureBuffer{limit: option.bodyLimit}
+			request.Body = teeReadCloser{request.Body, requestBody}
+		}
+
+		option.log(ctx, option.requestLevel, "request", append(attrs, option.headerAttr("request_header", redactor.header(request.Header)))...)
+
+		started := time.Now()
+		response, err := next(request)
+		if err != nil {
+			option.log(ctx, option.errorLevel, "request failed", append(attrs,
+				slog.Duration("duration", time.Since(started)),
+				slog.String("error", err.Error()),
+			)...)
+			return nil, err
+		}
+
+		level := option.responseLevel
+		switch {
+		case response.StatusCode >= 500:
+			level = option.serverErrorLevel
+		case response.StatusCode >= 400:
+			level = option.clientErrorLevel
+		}
+
+		if !option.logger.Enabled(ctx, level) {
+			return response, nil
+		}
+
+		responseBody := &captureBuffer{limit: option.bodyLimit}
+		once := sync.Once{}
+		finish := func() {
+			once.Do(func() {
+				attrs := append(attrs,
+					slog.Int("status", response.StatusCode),
+					slog.Duration("duration", time.Since(started)),
+					slog.Int64("request_size", requestBodySize(request, requestBody)),
+					slog.Int64("response_size", responseBody.size),
+					option.headerAttr("response_header", redactor.header(response.Header)),
+				)
+				if option.bodyLimit > 0 {
+					if requestBody != nil {
+						body := redactor.body(request.Header.Get("Content-Type"), requestBody.buf.Bytes())
+						attrs = append(attrs, slog.String("request_body", string(body)))
+					}
+					body := redactor.body(response.Header.Get("Content-Type"), responseBody.buf.Bytes())
+					attrs = append(attrs, slog.String("response_body", string(body)))
+				}
+				option.log(ctx, level, "response", attrs...)
+			})
+		}
+		response.Body = &captureBody{body: response.Body, buf: responseBody, finish: finish}
+		return response, nil
+	}
+}
+
+func (option LoggerOption) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	option.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func (option LoggerOption) headerAttr(key string, header http.Header) slog.Attr {
+	attrs := make([]any, 0, len(option.headers))
+	for _, k := range option.headers {
+		if v := header.Values(k); len(v) > 0 {
+			attrs = append(attrs, slog.Any(http.CanonicalHeaderKey(k), v))
+		}
+	}
+	return slog.Group(key, attrs...)
+}
+
+func requestBodySize(request *http.Request, body *captureBuffer) int64 {
+	if body != nil {
+		return body.size
+	}
+	return request.ContentLength
+}
+
+// 読み込んだレスポンスボディをbufにためて、読み終えるか閉じたときにfinishを呼ぶ。
+type captureBody struct {
+	body   io.ReadCloser
+	buf    *captureBuffer
+	finish func()
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.buf.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	err := b.body.Close()
+	b.finish()
+	return err
+}
@@ -0,0 +1,307 @@
+package rq
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// リクエスト数、実行中のリクエスト数、所要時間、リトライ回数、送受信したバイト数を集計するオプションをつくる。
+// クライアントのオプションにすると、クライアントがつくるすべてのリクエストを集計する。
+// リトライしたときは試行ごとに1回のリクエストとして数える。
+func NewMetrics() *Metrics {
+	return &Metrics{
+		namespace: "rq",
+		buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		series:    map[metricsKey]*metricsSeries{},
+	}
+}
+
+type Metrics struct {
+	namespace string
+	buckets   []float64
+
+	mu       sync.Mutex
+	inFlight int64
+	series   map[metricsKey]*metricsSeries
+}
+
+type metricsKey struct {
+	method string
+	host   string
+}
+
+type metricsSeries struct {
+	requests map[string]uint64 // ステータスコードのクラスごとのリクエスト数
+	retries  uint64
+	sent     uint64
+	received uint64
+	buckets  []uint64
+	count    uint64
+	sum      float64
+}
+
+func (m *Metrics) Apply(r *Request) {
+	r.middleware = append(r.middleware, m.middleware)
+}
+
+// メトリクス名の接頭辞をセットする。デフォルトは"rq"。
+func (m *Metrics) Namespace(namespace string) *Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.namespace = namespace
+	return m
+}
+
+// 所要時間のヒストグラムのバケットの上限(秒)をセットする。
+// すでに集計した所要時間のヒストグラムは捨てる。
+func (m *Metrics) Buckets(buckets ...float64) *Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buckets = append([]float64{}, buckets...)
+	sort.Float64s(m.buckets)
+	for _, s := range m.series {
+		s.buckets = make([]uint64, len(m.buckets))
+		s.count = 0
+		s.sum = 0
+	}
+	return m
+}
+
+func (m *Metrics) middleware(next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		key := metricsKey{method: request.Method, host: request.URL.Host}
+		retry := Attempt(request.Context()) > 1
+
+		var sent *captureBuffer
+		if request.Body != nil && request.Body != http.NoBody {
+			sent = &captureBuffer{}
+			request.Body = teeReadCloser{request.Body, sent}
+		}
+
+		m.mu.Lock()
+		m.inFlight++
+		m.mu.Unlock()
+
+		started := time.Now()
+		response, err := next(request)
+		if err != nil {
+			m.observe(key, "error", retry, sent, 0, time.Since(started))
+			return nil, err
+		}
+
+		received := &captureBuffer{}
+		once := sync.Once{}
+		finish := func() {
+			once.Do(func() {
+				m.observe(key, statusClass(response.StatusCode), retry, sent, received.size, time.Since(started))
+			})
+		}
+		response.Body = &captureBody{body: response.Body, buf: received, finish: finish}
+		return response, nil
+	}
+}
+
+func (m *Metrics) observe(key metricsKey, class string, retry bool, sent *captureBuffer, received int64, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight--
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricsSeries{requests: map[string]uint64{}, buckets: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	s.requests[class]++
+	if retry {
+		s.retries++
+	}
+	if sent != nil {
+		s.sent += uint64(sent.size)
+	}
+	s.received += uint64(received)
+
+	seconds := duration.Seconds()
+	for i, le := range m.buckets {
+		if seconds <= le {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.sum += seconds
+}
+
+func statusClass(statusCode int) string {
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// 集計したメトリクスをPrometheusのテキスト形式で返すハンドラーを返す。
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+}
+
+// 集計したメトリクスをPrometheusのテキスト形式でwに出力する。
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, family := range m.families() {
+		fmt.Fprintf(bw, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", family.name, family.kind)
+		for _, sample := range family.samples {
+			bw.WriteString(family.name + sample.suffix)
+			if len(sample.labels) > 0 {
+				bw.WriteString("{")
+				for i, label := range sample.labels {
+					if i > 0 {
+						bw.WriteString(",")
+					}
+					bw.WriteString(label[0] + `="` + escapeLabelValue(label[1]) + `"`)
+				}
+				bw.WriteString("}")
+			}
+			bw.WriteString(" " + formatFloat(sample.value) + "\n")
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// 集計したメトリクスをexpvarにnameで公開する。同じnameで2回呼ぶとpanicする。
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		vars := map[string]any{}
+		for _, family := range m.families() {
+			for _, sample := range family.samples {
+				labels := map[string]string{}
+				for _, label := range sample.labels {
+					labels[label[0]] = label[1]
+				}
+				name := family.name + sample.suffix
+				samples, _ := vars[name].([]any)
+				vars[name] = append(samples, map[string]any{"labels": labels, "value": sample.value})
+			}
+		}
+		return vars
+	}))
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	suffix string
+	labels [][2]string
+	value  float64
+}
+
+// 集計したメトリクスのスナップショットを、名前とラベルの順にならべて返す。
+func (m *Metrics) families() []metricFamily {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]metricsKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	name := func(s string) string {
+		if m.namespace == "" {
+			return s
+		}
+		return m.namespace + "_" + s
+	}
+	requests := metricFamily{name: name("requests_total"), help: "Total number of HTTP requests by method, host and status class.", kind: "counter"}
+	inFlight := metricFamily{name: name("requests_in_flight"), help: "Number of HTTP requests in flight.", kind: "gauge"}
+	duration := metricFamily{name: name("request_duration_seconds"), help: "Duration of HTTP requests until the response body is read.", kind: "histogram"}
+	retries := metricFamily{name: name("retries_total"), help: "Total number of retried HTTP requests.", kind: "counter"}
+	sent := metricFamily{name: name("sent_bytes_total"), help: "Total number of request body bytes sent.", kind: "counter"}
+	received := metricFamily{name: name("received_bytes_total"), help: "Total number of response body bytes received.", kind: "counter"}
+
+	inFlight.samples = append(inFlight.samples, metricSample{value: float64(m.inFlight)})
+	for _, key := range keys {
+		s := m.series[key]
+		labels := [][2]string{{"method", key.method}, {"host", key.host}}
+
+		classes := make([]string, 0, len(s.requests))
+		for class := range s.requests {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			requests.samples = append(requests.samples, metricSample{
+				labels: append(labels[:2:2], [2]string{"status_class", class}),
+				value:  float64(s.requests[class]),
+			})
+		}
+
+		for i, le := range m.buckets {
+			duration.samples = append(duration.samples, metricSample{
+				suffix: "_bucket",
+				labels: append(labels[:2:2], [2]string{"le", formatFloat(le)}),
+				value:  float64(s.buckets[i]),
+			})
+		}
+		duration.samples = append(duration.samples,
+			metricSample{suffix: "_bucket", labels: append(labels[:2:2], [2]string{"le", "+Inf"}), value: float64(s.count)},
+			metricSample{suffix: "_sum", labels: labels, value: s.sum},
+			metricSample{suffix: "_count", labels: labels, value: float64(s.count)},
+		)
+
+		retries.samples = append(retries.samples, metricSample{labels: labels, value: float64(s.retries)})
+		sent.samples = append(sent.samples, metricSample{labels: labels, value: float64(s.sent)})
+		received.samples = append(received.samples, metricSample{labels: labels, value: float64(s.received)})
+	}
+
+	return []metricFamily{requests, inFlight, duration, retries, sent, received}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
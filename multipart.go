@@ -0,0 +1,197 @@
+package rq
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// multipart/form-dataのリクエストボディをセットする。
+// ボディはメモリにためずにストリーミングで送信する。
+func BodyMultipart() MultipartOption {
+	return MultipartOption{}
+}
+
+type MultipartOption struct {
+	parts []multipartPart
+}
+
+type multipartPart struct {
+	name        string
+	filename    string
+	contentType string
+	value       string
+	reader      io.Reader
+	path        string
+}
+
+func (option MultipartOption) Apply(r *Request) {
+	boundary := randomBoundary()
+
+	r.With(ContentType("multipart/form-data").Boundary(boundary))
+
+	// 読み込まれるまではゴルーチンを起動せず、ファイルも開かない。
+	open := func() io.ReadCloser {
+		pr, pw := io.Pipe()
+		go func() {
+			mw := multipart.NewWriter(pw)
+			err := mw.SetBoundary(boundary)
+			if err == nil {
+				err = option.write(mw)
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		return pr
+	}
+
+	if option.replayable() {
+		r.body = nil
+		r.getBody = func() (io.Reader, error) {
+			return &multipartBody{lazyReadCloser{open: open}, option.parts}, nil
+		}
+	} else {
+		r.body = &multipartBody{lazyReadCloser{open: open}, option.parts}
+		r.getBody = nil
+	}
+}
+
+// テキストのフィールドを追加する。
+func (option MultipartOption) Field(name string, value string) MultipartOption {
+	return option.add(multipartPart{name: name, value: value})
+}
+
+// io.Readerの内容をファイルとして追加する。
+func (option MultipartOption) File(name string, filename string, r io.Reader) MultipartOption {
+	return option.FileContentType(name, filename, "application/octet-stream", r)
+}
+
+// io.Readerの内容をContent-Typeを指定してファイルとして追加する。
+func (option MultipartOption) FileContentType(name string, filename string, contentType string, r io.Reader) MultipartOption {
+	return option.add(multipartPart{name: name, filename: filename, contentType: contentType, reader: r})
+}
+
+// パスのファイルを追加する。Content-Typeは拡張子から決める。
+func (option MultipartOption) FilePath(name string, path string) MultipartOption {
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return option.FilePathContentType(name, path, contentType)
+}
+
+// パスのファイルをContent-Typeを指定して追加する。
+func (option MultipartOption) FilePathContentType(name string, path string, contentType string) MultipartOption {
+	return option.add(multipartPart{name: name, filename: filepath.Base(path), contentType: contentType, path: path})
+}
+
+func (option MultipartOption) add(part multipartPart) MultipartOption {
+	parts := make([]multipartPart, 0, len(option.parts)+1)
+	parts = append(parts, option.parts...)
+	parts = append(parts, part)
+	return MultipartOption{parts}
+}
+
+// io.Readerのパートがなければ何度でもボディをつくりなおせる。
+func (option MultipartOption) replayable() bool {
+	for _, part := range option.parts {
+		if part.reader != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (option MultipartOption) write(mw *multipart.Writer) error {
+	for _, part := range option.parts {
+		if part.filename == "" {
+			if err := mw.WriteField(part.name, part.value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(part.name), escapeQuotes(part.filename)))
+		header.Set("Content-Type", part.contentType)
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		if part.path != "" {
+			if err := copyFile(w, part.path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := io.Copy(w, part.reader); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+func randomBoundary() string {
+	var buf [30]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", buf[:])
+}
+
+// multipart/form-dataのリクエストボディ。curlコマンドをつくるときはpartsを参照する。
+type multipartBody struct {
+	lazyReadCloser
+	parts []multipartPart
+}
+
+// 最初に読み込まれたときにひらくio.ReadCloser。
+type lazyReadCloser struct {
+	open func() io.ReadCloser
+
+	once sync.Once
+	rc   io.ReadCloser
+}
+
+func (l *lazyReadCloser) Read(p []byte) (int, error) {
+	l.once.Do(func() {
+		l.rc = l.open()
+	})
+	return l.rc.Read(p)
+}
+
+func (l *lazyReadCloser) Close() error {
+	l.once.Do(func() {
+		l.rc = io.NopCloser(strings.NewReader(""))
+	})
+	return l.rc.Close()
+}
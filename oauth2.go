@@ -0,0 +1,262 @@
+package rq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth2のクライアントクレデンシャルフローで取得したアクセストークンをAuthorizationヘッダにセットする。
+// トークンは有効期限の少し前まで使いまわし、ステータスコード401のときは1回だけトークンを取得しなおして再送する。
+// クライアントのオプションにすると、クライアントがつくるすべてのリクエストでトークンを共有する。
+func OAuth2ClientCredentials(tokenURL string, clientID string, clientSecret string, scopes ...string) *OAuth2TokenSource {
+	return &OAuth2TokenSource{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		params:       url.Values{},
+		expiryDelta:  10 * time.Second,
+	}
+}
+
+// OAuth2のリフレッシュトークンで取得したアクセストークンをAuthorizationヘッダにセットする。
+// サーバーが新しいリフレッシュトークンを返したときは、以降はそれを使う。
+// トークンの扱いはOAuth2ClientCredentialsと同じ。
+func OAuth2RefreshToken(tokenURL string, clientID string, clientSecret string, refreshToken string, scopes ...string) *OAuth2TokenSource {
+	s := OAuth2ClientCredentials(tokenURL, clientID, clientSecret, scopes...)
+	s.refreshToken = refreshToken
+	return s
+}
+
+// トークンエンドポイントが返すトークン。
+type OAuth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresIn    int64     `json:"expires_in,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	Expiry       time.Time `json:"-"` // ExpiresInから計算した有効期限。ExpiresInがないときはゼロ値。
+}
+
+// トークンエンドポイントが返すエラー。ErrorPayload[OAuth2Error]で取り出せる。
+type OAuth2Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	URI         string `json:"error_uri,omitempty"`
+}
+
+func (err OAuth2Error) Error() string {
+	if err.Description != "" {
+		return "oauth2: " + err.Code + ": " + err.Description
+	}
+	return "oauth2: " + err.Code
+}
+
+type OAuth2TokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	params       url.Values
+	secretPost   bool
+	expiryDelta  time.Duration
+	tokenOptions []Option
+	onToken      func(OAuth2Token)
+	refreshToken string
+	mu           sync.Mutex
+	token        *OAuth2Token
+	fetching     chan struct{}
+}
+
+func (s *OAuth2TokenSource) Apply(r *Request) {
+	r.middleware = append(r.middleware, s.middleware)
+}
+
+// クライアントIDとシークレットをAuthorizationヘッダではなくリクエストボディで送信する(client_secret_post)。
+// デフォルトはAuthorizationヘッダで送信する(client_secret_basic)。
+func (s *OAuth2TokenSource) ClientSecretPost() *OAuth2TokenSource {
+	s.secretPost = true
+	return s
+}
+
+// トークンリクエストに追加するパラメータをセットする。
+func (s *OAuth2TokenSource) Param(key string, value ...string) *OAuth2TokenSource {
+	s.params[key] = value
+	return s
+}
+
+// 有効期限のどれだけ前にトークンを取得しなおすかをセットする。デフォルトは10秒。
+func (s *OAuth2TokenSource) ExpiryDelta(d time.Duration) *OAuth2TokenSource {
+	s.expiryDelta = d
+	return s
+}
+
+// トークンリクエストに適用するオプションをセットする。
+func (s *OAuth2TokenSource) TokenOptions(options ...Option) *OAuth2TokenSource {
+	s.tokenOptions = append(s.tokenOptions, options...)
+	return s
+}
+
+// トークンを取得したときに呼ばれる関数をセットする。リフレッシュトークンを保存するのに使う。
+func (s *OAuth2TokenSource) OnToken(f func(OAuth2Token)) *OAuth2TokenSource {
+	s.onToken = f
+	return s
+}
+
+// 有効なトークンを返す。キャッシュしたトークンが期限切れのときは取得しなおす。
+// 同時に呼ばれても、トークンエンドポイントへのリクエストは1件だけにまとめる。
+func (s *OAuth2TokenSource) Token(ctx context.Context) (OAuth2Token, error) {
+	token, err := s.get(ctx)
+	if err != nil {
+		return OAuth2Token{}, err
+	}
+	return *token, nil
+}
+
+func (s *OAuth2TokenSource) get(ctx context.Context) (*OAuth2Token, error) {
+	for {
+		s.mu.Lock()
+		if s.token != nil && (s.token.Expiry.IsZero() || time.Now().Add(s.expiryDelta).Before(s.token.Expiry)) {
+			token := s.token
+			s.mu.Unlock()
+			return token, nil
+		}
+		if s.fetching != nil {
+			fetching := s.fetching
+			s.mu.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		fetching := make(chan struct{})
+		s.fetching = fetching
+		refreshToken := s.refreshToken
+		s.mu.Unlock()
+
+		token, err := s.fetch(ctx, refreshToken)
+
+		s.mu.Lock()
+		s.fetching = nil
+		if err == nil {
+			s.token = token
+			if token.RefreshToken != "" {
+				s.refreshToken = token.RefreshToken
+			}
+		}
+		s.mu.Unlock()
+		close(fetching)
+
+		if err != nil {
+			return nil, err
+		}
+		if s.onToken != nil {
+			s.onToken(*token)
+		}
+		return token, nil
+	}
+}
+
+// tokenが最新のトークンなら捨てる。
+func (s *OAuth2TokenSource) invalidate(token *OAuth2Token) {
+	s.mu.Lock()
+	if s.token == token {
+		s.token = nil
+	}
+	s.mu.Unlock()
+}
+
+func (s *OAuth2TokenSource) fetch(ctx context.Context, refreshToken string) (*OAuth2Token, error) {
+	form := url.Values{}
+	for k, v := range s.params {
+		form[k] = v
+	}
+	if refreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+
+	options := append([]Option{}, s.tokenOptions...)
+	if s.secretPost {
+		form.Set("client_id", s.clientID)
+		if s.clientSecret != "" {
+			form.Set("client_secret", s.clientSecret)
+		}
+	} else {
+		options = append(options, AuthorizationBasic(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret)))
+	}
+	options = append(options, Context(ctx), BodyFormURLEncoded(form))
+
+	started := time.Now()
+	token, err := JSONOrError[OAuth2Token, OAuth2Error](Post(s.tokenURL, options...))
+	if err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("oauth2: token response has no access_token")
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = started.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return &token, nil
+}
+
+func (s *OAuth2TokenSource) middleware(next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		token, err := s.get(request.Context())
+		if err != nil {
+			if request.Body != nil {
+				request.Body.Close()
+			}
+			return nil, err
+		}
+
+		replayable := request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+		retry := request
+		if replayable {
+			retry = request.Clone(request.Context())
+		}
+
+		request.Header.Set("Authorization", authorization(token))
+		response, err := next(request)
+		if err != nil || response.StatusCode != http.StatusUnauthorized || !replayable {
+			return response, err
+		}
+
+		s.invalidate(token)
+		token, err = s.get(request.Context())
+		if err != nil {
+			return response, nil
+		}
+		if request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return response, nil
+			}
+			retry.Body = body
+		}
+		response.Body.Close()
+
+		retry.Header.Set("Authorization", authorization(token))
+		return next(retry)
+	}
+}
+
+func authorization(token *OAuth2Token) string {
+	if token.TokenType == "" || strings.EqualFold(token.TokenType, "bearer") {
+		return "Bearer " + token.AccessToken
+	}
+	return token.TokenType + " " + token.AccessToken
+}
@@ -0,0 +1,134 @@
+package rq
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// RFC 9457 Problem Details。
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any // 標準以外のメンバー。
+}
+
+func (p *Problem) Error() string {
+	switch {
+	case p.Title != "" && p.Detail != "":
+		return p.Title + ": " + p.Detail
+	case p.Title != "":
+		return p.Title
+	case p.Detail != "":
+		return p.Detail
+	}
+	return p.Type
+}
+
+// Content-Typeがapplication/problem+jsonかapplication/problem+xmlならレスポンスボディをパースする。
+func parseProblem(contentType string, body []byte) *Problem {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	switch mediaType {
+	case "application/problem+json":
+		return parseProblemJSON(body)
+	case "application/problem+xml":
+		return parseProblemXML(body)
+	}
+	return nil
+}
+
+func parseProblemJSON(body []byte) *Problem {
+	members := map[string]any{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&members); err != nil {
+		return nil
+	}
+
+	p := &Problem{Extensions: map[string]any{}}
+	for k, v := range members {
+		switch k {
+		case "type":
+			p.Type, _ = v.(string)
+		case "title":
+			p.Title, _ = v.(string)
+		case "status":
+			if n, ok := v.(json.Number); ok {
+				status, _ := n.Int64()
+				p.Status = int(status)
+			}
+		case "detail":
+			p.Detail, _ = v.(string)
+		case "instance":
+			p.Instance, _ = v.(string)
+		default:
+			p.Extensions[k] = v
+		}
+	}
+	return p
+}
+
+func parseProblemXML(body []byte) *Problem {
+	p := &Problem{Extensions: map[string]any{}}
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	root := false
+	depth := 0
+	name := ""
+	text := strings.Builder{}
+	for {
+		t, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				root = true
+			}
+			if depth == 2 {
+				name = t.Name.Local
+				text.Reset()
+			}
+		case xml.CharData:
+			if depth == 2 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				v := strings.TrimSpace(text.String())
+				switch name {
+				case "type":
+					p.Type = v
+				case "title":
+					p.Title = v
+				case "status":
+					p.Status, _ = strconv.Atoi(v)
+				case "detail":
+					p.Detail = v
+				case "instance":
+					p.Instance = v
+				default:
+					p.Extensions[name] = v
+				}
+			}
+			depth--
+		}
+	}
+
+	if !root || depth != 0 {
+		return nil
+	}
+	return p
+}
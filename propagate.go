@@ -0,0 +1,189 @@
+package rq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// コンテキストのトレースコンテキストとリクエストIDを、traceparent、tracestate、X-Request-IDヘッダで送信する。
+// コンテキストにないときは新しいIDをつくる。IDはDoごとに決まり、リトライしても同じものを送信する。
+// すでにセットされているヘッダは上書きしない。
+func Propagate() PropagateOption {
+	return PropagateOption{requestIDHeader: "X-Request-Id"}
+}
+
+type PropagateOption struct {
+	traceContext    []func(context.Context) (traceparent string, tracestate string)
+	requestID       []func(context.Context) string
+	requestIDHeader string
+}
+
+func (option PropagateOption) Apply(r *Request) {
+	r.requestIDHeader = option.requestIDHeader
+	r.aroundHook = append(r.aroundHook, option.around)
+	r.preHook = append(r.preHook, option.preHook)
+}
+
+// コンテキストからtraceparentとtracestateを取り出す関数を追加する。
+// 追加した順に呼び出して、有効なtraceparentを返した最初の関数の値を使う。どれも返さなければWithTraceContextの値を使う。
+func (option PropagateOption) TraceContext(extractor func(ctx context.Context) (traceparent string, tracestate string)) PropagateOption {
+	option.traceContext = append(append([]func(context.Context) (string, string){}, option.traceContext...), extractor)
+	return option
+}
+
+// コンテキストからリクエストIDを取り出す関数を追加する。
+// 追加した順に呼び出して、空でない値を返した最初の関数の値を使う。どれも返さなければWithRequestIDの値を使う。
+func (option PropagateOption) RequestID(extractor func(ctx context.Context) string) PropagateOption {
+	option.requestID = append(append([]func(context.Context) string{}, option.requestID...), extractor)
+	return option
+}
+
+// リクエストIDを送信するヘッダをセットする。空文字列のときはリクエストIDを送信しない。
+func (option PropagateOption) RequestIDHeader(key string) PropagateOption {
+	option.requestIDHeader = http.CanonicalHeaderKey(key)
+	return option
+}
+
+type propagationKey struct{}
+
+type propagation struct {
+	traceParent string
+	traceState  string
+	requestID   string
+}
+
+// Doの間は同じIDを使うように、コンテキストにIDを固定する。
+func (option PropagateOption) around(ctx context.Context, next func(context.Context) (*http.Response, error)) (*http.Response, error) {
+	p := option.extract(ctx)
+	return next(context.WithValue(ctx, propagationKey{}, p))
+}
+
+func (option PropagateOption) preHook(request *http.Request) error {
+	p, ok := request.Context().Value(propagationKey{}).(propagation)
+	if !ok {
+		p = option.extract(request.Context())
+	}
+
+	if request.Header.Get("Traceparent") == "" {
+		request.Header.Set("Traceparent", p.traceParent)
+		if p.traceState != "" {
+			request.Header.Set("Tracestate", p.traceState)
+		}
+	}
+	if option.requestIDHeader != "" && request.Header.Get(option.requestIDHeader) == "" {
+		request.Header.Set(option.requestIDHeader, p.requestID)
+	}
+	return nil
+}
+
+func (option PropagateOption) extract(ctx context.Context) propagation {
+	p := propagation{}
+
+	for _, extractor := range append(option.traceContext, TraceContextFromContext) {
+		if traceParent, traceState := extractor(ctx); validTraceParent(traceParent) {
+			p.traceParent, p.traceState = traceParent, traceState
+			break
+		}
+	}
+	if p.traceParent == "" {
+		p.traceParent = "00-" + randomHex(16) + "-" + randomHex(8) + "-01"
+	}
+
+	for _, extractor := range append(option.requestID, RequestIDFromContext) {
+		if requestID := extractor(ctx); requestID != "" {
+			p.requestID = requestID
+			break
+		}
+	}
+	if p.requestID == "" {
+		p.requestID = newUUID()
+	}
+
+	return p
+}
+
+type traceContextKey struct{}
+
+type traceContext struct {
+	traceParent string
+	traceState  string
+}
+
+// Propagateで送信するtraceparentとtracestateをコンテキストにセットする。
+func WithTraceContext(ctx context.Context, traceparent string, tracestate string) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, traceContext{traceParent: traceparent, traceState: tracestate})
+}
+
+// WithTraceContextでセットしたtraceparentとtracestateを返す。
+func TraceContextFromContext(ctx context.Context) (traceparent string, tracestate string) {
+	tc, _ := ctx.Value(traceContextKey{}).(traceContext)
+	return tc.traceParent, tc.traceState
+}
+
+type requestIDKey struct{}
+
+// Propagateで送信するリクエストIDをコンテキストにセットする。
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// WithRequestIDでセットしたリクエストIDを返す。
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// W3C Trace Contextのtraceparentとして正しいかどうか。
+func validTraceParent(s string) bool {
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 {
+		return false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" {
+		return false
+	}
+	if version == "00" && len(parts) != 4 {
+		return false
+	}
+	if len(traceID) != 32 || !isLowerHex(traceID) || strings.Trim(traceID, "0") == "" {
+		return false
+	}
+	if len(parentID) != 16 || !isLowerHex(parentID) || strings.Trim(parentID, "0") == "" {
+		return false
+	}
+	return len(flags) == 2 && isLowerHex(flags)
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// ランダムなUUID(バージョン4)をつくる。
+func newUUID() string {
+	var buf [16]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		panic(err)
+	}
+	buf[6] = buf[6]&0x0f | 0x40
+	buf[8] = buf[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:16])
+}
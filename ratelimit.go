@@ -0,0 +1,121 @@
+package rq
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 1秒あたりrps回、最大burst回まで連続してリクエストできるようにレート制限する。
+// クライアントのオプションにすると、クライアントがつくるすべてのリクエストで制限を共有する。
+func RateLimit(rps float64, burst int) RateLimitOption {
+	return RateLimitOption{newRateLimiter(rps, burst, false)}
+}
+
+type RateLimitOption struct {
+	limiter *rateLimiter
+}
+
+func (option RateLimitOption) Apply(r *Request) {
+	r.middleware = append(r.middleware, option.limiter.middleware)
+}
+
+// ホストごとにレート制限する。
+func (option RateLimitOption) PerHost() RateLimitOption {
+	return RateLimitOption{newRateLimiter(option.limiter.rps, option.limiter.burst, true)}
+}
+
+func newRateLimiter(rps float64, burst int, perHost bool) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rps:     rps,
+		burst:   burst,
+		perHost: perHost,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+type rateLimiter struct {
+	rps     float64
+	burst   int
+	perHost bool
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 送信する前にトークンを取得できるまで待つ。BuildやCurlではトークンを消費しない。
+func (l *rateLimiter) middleware(next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		if err := l.wait(request.Context(), request.URL.Host); err != nil {
+			if request.Body != nil {
+				request.Body.Close()
+			}
+			return nil, err
+		}
+		return next(request)
+	}
+}
+
+// トークンを取得できるまで待つ。
+func (l *rateLimiter) wait(ctx context.Context, host string) error {
+	if l.rps <= 0 {
+		return nil
+	}
+
+	key := ""
+	if l.perHost {
+		key = host
+	}
+
+	wait := l.reserve(key)
+	if wait <= 0 {
+		return nil
+	}
+	if err := sleep(ctx, wait); err != nil {
+		l.cancel(key)
+		return err
+	}
+	return nil
+}
+
+func (l *rateLimiter) reserve(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rps
+	if bucket.tokens > float64(l.burst) {
+		bucket.tokens = float64(l.burst)
+	}
+	bucket.last = now
+
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / l.rps * float64(time.Second))
+}
+
+func (l *rateLimiter) cancel(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bucket, ok := l.buckets[key]; ok {
+		bucket.tokens++
+	}
+}
@@ -0,0 +1,200 @@
+package rq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 伏せた値。
+const redacted = "***"
+
+// Verbose、ResponseError、Logger、Curl、HARなどで伏せる秘密情報をセットする。
+// 何もセットしなくても、Authorization、Proxy-Authorization、Cookie、Set-Cookieヘッダと、
+// access_token、api_key、apikey、client_secret、password、tokenクエリは伏せる。
+func Redact() RedactOption {
+	return RedactOption{defaultRedactor}
+}
+
+type RedactOption struct {
+	redactor *redactor
+}
+
+func (option RedactOption) Apply(r *Request) {
+	r.redactor = option.redactor
+}
+
+// 値を伏せるヘッダを追加する。
+func (option RedactOption) Header(key ...string) RedactOption {
+	redactor := option.redactor.clone()
+	for _, k := range key {
+		redactor.headers = append(redactor.headers, http.CanonicalHeaderKey(k))
+	}
+	return RedactOption{redactor}
+}
+
+// 値を伏せるURLクエリを追加する。x-www-form-urlencodedのリクエストボディにも使う。
+func (option RedactOption) Query(key ...string) RedactOption {
+	redactor := option.redactor.clone()
+	redactor.queries = append(redactor.queries, key...)
+	return RedactOption{redactor}
+}
+
+// 値を伏せるJSONボディのフィールドをJSONポインタで追加する。"*"はすべてのキーと要素にマッチする。
+func (option RedactOption) JSONField(pointer ...string) RedactOption {
+	redactor := option.redactor.clone()
+	for _, p := range pointer {
+		if tokens, err := parseJSONPointer(p); err == nil {
+			redactor.jsonFields = append(redactor.jsonFields, tokens)
+		}
+	}
+	return RedactOption{redactor}
+}
+
+// 秘密情報を伏せない。
+func NoRedact() Option {
+	return OptionFunc(func(r *Request) {
+		r.redactor = &redactor{}
+	})
+}
+
+var defaultRedactor = &redactor{
+	headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+	queries: []string{"access_token", "api_key", "apikey", "client_secret", "password", "token"},
+}
+
+type redactor struct {
+	headers    []string
+	queries    []string
+	jsonFields [][]string
+}
+
+func (rd *redactor) clone() *redactor {
+	return &redactor{
+		headers:    append([]string{}, rd.headers...),
+		queries:    append([]string{}, rd.queries...),
+		jsonFields: append([][]string{}, rd.jsonFields...),
+	}
+}
+
+func (rd *redactor) isHeader(key string) bool {
+	return containsFold(rd.headers, key)
+}
+
+func (rd *redactor) isQuery(key string) bool {
+	for _, q := range rd.queries {
+		if q == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ヘッダの値を伏せたコピーを返す。
+func (rd *redactor) header(header http.Header) http.Header {
+	h := header.Clone()
+	for k, v := range h {
+		if rd.isHeader(k) {
+			values := make([]string, len(v))
+			for i := range values {
+				values[i] = redacted
+			}
+			h[k] = values
+		}
+	}
+	return h
+}
+
+// URLのパスワードとクエリの値を伏せた文字列を返す。
+func (rd *redactor) url(u *url.URL) string {
+	v := *u
+	if _, ok := v.User.Password(); ok {
+		v.User = url.User(v.User.Username())
+	}
+	v.RawQuery = rd.rawQuery(v.RawQuery)
+	s := v.String()
+	if _, ok := u.User.Password(); ok {
+		s = strings.Replace(s, v.User.String()+"@", v.User.String()+":"+redacted+"@", 1)
+	}
+	return s
+}
+
+// x-www-form-urlencoded形式の値を、順番とエンコードをそのままにして伏せる。
+func (rd *redactor) rawQuery(rawQuery string) string {
+	if rawQuery == "" || len(rd.queries) == 0 {
+		return rawQuery
+	}
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		k, _, ok := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(k)
+		if err != nil {
+			key = k
+		}
+		if ok && rd.isQuery(key) {
+			pairs[i] = k + "=" + redacted
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Content-Typeに応じてボディの値を伏せる。
+func (rd *redactor) body(contentType string, body []byte) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return []byte(rd.rawQuery(string(body)))
+	case len(rd.jsonFields) > 0 && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")):
+		return rd.json(body)
+	}
+	return body
+}
+
+// JSONのフィールドを伏せる。途中で切れているなどでパースできないときは、ボディ全体を伏せる。
+func (rd *redactor) json(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return redactedBody(body)
+	}
+	for _, tokens := range rd.jsonFields {
+		v = redactJSON(v, tokens)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return redactedBody(body)
+	}
+	return b
+}
+
+func redactedBody(body []byte) []byte {
+	return []byte(fmt.Sprintf("[%d bytes, redacted]", len(body)))
+}
+
+func redactJSON(v any, tokens []string) any {
+	if len(tokens) == 0 {
+		return redacted
+	}
+	token, rest := tokens[0], tokens[1:]
+	switch v := v.(type) {
+	case map[string]any:
+		for k := range v {
+			if token == "*" || token == k {
+				v[k] = redactJSON(v[k], rest)
+			}
+		}
+	case []any:
+		for i := range v {
+			if token == "*" || token == strconv.Itoa(i) {
+				v[i] = redactJSON(v[i], rest)
+			}
+		}
+	}
+	return v
+}
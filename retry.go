@@ -0,0 +1,182 @@
+package rq
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// リトライする。
+// 通信エラーと指定されたステータスコードのとき、指数バックオフで最大max回までリトライする。
+// 冪等でないメソッドのリクエストはリトライしない。
+// Retry-Afterヘッダがあるときはその時間だけ待つが、バックオフの最大待ち時間より長いときはリトライしない。
+func Retry(max int) RetryOption {
+	return RetryOption{
+		max:     max,
+		minWait: 100 * time.Millisecond,
+		maxWait: 30 * time.Second,
+		errs: []error{
+			ErrTooManyRequests,
+			ErrBadGateway,
+			ErrServiceUnavailable,
+			ErrGatewayTimeout,
+		},
+		nonIdempotent: false,
+	}
+}
+
+type RetryOption struct {
+	max           int
+	minWait       time.Duration
+	maxWait       time.Duration
+	errs          []error
+	nonIdempotent bool
+}
+
+func (option RetryOption) Apply(r *Request) {
+	r.retry = &option
+}
+
+// バックオフの最小待ち時間と最大待ち時間をセットする。
+func (option RetryOption) Backoff(min time.Duration, max time.Duration) RetryOption {
+	option.minWait = min
+	option.maxWait = max
+	return option
+}
+
+// リトライするステータスコードのエラーをセットする。
+func (option RetryOption) On(err ...error) RetryOption {
+	option.errs = append([]error{}, err...)
+	return option
+}
+
+// 冪等でないメソッドのリクエストもリトライする。
+func (option RetryOption) NonIdempotent() RetryOption {
+	option.nonIdempotent = true
+	return option
+}
+
+// attempt回目の結果からリトライするかどうかと待ち時間を返す。
+func (option *RetryOption) next(attempt int, request *http.Request, response *http.Response, err error) (time.Duration, bool) {
+	if attempt > option.max {
+		return 0, false
+	}
+
+	if !option.nonIdempotent && !isIdempotent(request) {
+		return 0, false
+	}
+
+	if err != nil {
+		if request.Context().Err() != nil || errors.Is(err, ErrCircuitOpen) {
+			return 0, false
+		}
+		return option.backoff(attempt), true
+	}
+
+	if response.Request != nil && noRetry(response.Request.Context()) {
+		return 0, false
+	}
+
+	for _, e := range option.errs {
+		if errors.Is(statusCodeError(response.StatusCode), e) {
+			if wait, ok := retryAfter(response.Header.Get("Retry-After")); ok {
+				// サーバーが指定した待ち時間が最大待ち時間より長いときは、待たずにそのレスポンスを返す。
+				if wait > option.maxWait {
+					return 0, false
+				}
+				return wait, true
+			}
+			return option.backoff(attempt), true
+		}
+	}
+
+	return 0, false
+}
+
+func (option *RetryOption) backoff(attempt int) time.Duration {
+	wait := option.minWait
+	for i := 1; i < attempt && wait < option.maxWait; i++ {
+		wait *= 2
+	}
+	if wait > option.maxWait {
+		wait = option.maxWait
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+func isIdempotent(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if _, ok := request.Header["Idempotency-Key"]; ok {
+		return true
+	}
+	if _, ok := request.Header["X-Idempotency-Key"]; ok {
+		return true
+	}
+	return false
+}
+
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		wait := time.Until(t)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+type attemptKey struct{}
+
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// リクエストのコンテキストから何回目の試行か返す。PreHookやミドルウェアで使う。
+func Attempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+type noRetryKey struct{}
+
+// リトライしないレスポンスのリクエストにつけるコンテキストをつくる。
+func withNoRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+func noRetry(ctx context.Context) bool {
+	v, _ := ctx.Value(noRetryKey{}).(bool)
+	return v
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -62,6 +62,7 @@ func NewRequest(method string, url string, options ...Option) *Request {
 		body:         nil,
 		err:          nil,
 		errBodyLimit: 4096,
+		redactor:     defaultRedactor,
 		query:        _url.Values{},
 		header:       http.Header{},
 		client:       http.DefaultClient,
@@ -76,10 +77,19 @@ type Request struct {
 	body   io.Reader
 	err    error
 
+	getBody func() (io.Reader, error)
+	retry   *RetryOption
+
 	errBodyLimit int64
+	errPayload   func([]byte) (any, bool)
+	redactor     *redactor
+
+	requestIDHeader string
 
-	preHook  []func(*http.Request) error
-	postHook []func(*http.Response) error
+	aroundHook []func(context.Context, func(context.Context) (*http.Response, error)) (*http.Response, error)
+	preHook    []func(*http.Request) error
+	postHook   []func(*http.Response) error
+	middleware []func(doFunc) doFunc
 
 	query  _url.Values
 	header http.Header
@@ -101,6 +111,103 @@ func (r *Request) Do() (*http.Response, error) {
 		return nil, r.err
 	}
 
+	if len(r.aroundHook) > 0 {
+		ctx := r.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		hook := r.aroundHook[0]
+		return hook(ctx, func(ctx context.Context) (*http.Response, error) {
+			next := *r
+			next.ctx = ctx
+			next.aroundHook = r.aroundHook[1:]
+			return next.Do()
+		})
+	}
+
+	for attempt := 1; ; attempt++ {
+		request, err := r.build(attempt, false)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := r.send(request)
+		if err == nil {
+			body := response.Body
+			response.Body = readCloser{
+				read: body.Read,
+				close: func() error {
+					_, err := io.Copy(io.Discard, body)
+					if err1 := body.Close(); err == nil {
+						err = err1
+					}
+					return err
+				},
+			}
+		}
+
+		if r.retry != nil && r.replayable() {
+			if delay, ok := r.retry.next(attempt, request, response, err); ok {
+				if err == nil {
+					response.Body.Close()
+				}
+				if err := sleep(request.Context(), delay); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hook := range r.postHook {
+			if err := hook(response); err != nil {
+				response.Body.Close()
+				return nil, err
+			}
+		}
+
+		return response, nil
+	}
+}
+
+// 送信するリクエストをつくる。
+// URLクエリとヘッダをまとめてPreHookを実行した、Doが送信するものと同じリクエストを返す。
+func (r *Request) Build() (*http.Request, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.build(1, false)
+}
+
+// attempt回目の試行で送信するリクエストをつくる。
+// dryRunのときは、再送できないリクエストボディを読み込まないプレースホルダーに置き換える。
+func (r *Request) build(attempt int, dryRun bool) (*http.Request, error) {
+	request, err := r.newHTTPRequest(dryRun)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(withAttempt(request.Context(), attempt))
+
+	if err := r.runPreHooks(request); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// ミドルウェアを通してリクエストを送信する。
+func (r *Request) send(request *http.Request) (*http.Response, error) {
+	do := r.client.Do
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		do = r.middleware[i](do)
+	}
+	return do(request)
+}
+
+func (r *Request) newHTTPRequest(dryRun bool) (*http.Request, error) {
 	url, err := _url.Parse(r.url)
 	if err != nil {
 		return nil, err
@@ -112,13 +219,37 @@ func (r *Request) Do() (*http.Response, error) {
 	}
 	url.RawQuery = query.Encode()
 
-	request, err := http.NewRequest(r.method, url.String(), r.body)
+	body := r.body
+	if r.getBody != nil {
+		body, err = r.getBody()
+		if err != nil {
+			return nil, err
+		}
+	} else if body != nil && dryRun {
+		body = unreplayableBody{}
+	}
+
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, r.method, url.String(), body)
 	if err != nil {
 		return nil, err
 	}
 
-	if r.ctx != nil {
-		request.WithContext(r.ctx)
+	if r.getBody != nil {
+		request.GetBody = func() (io.ReadCloser, error) {
+			body, err := r.getBody()
+			if err != nil {
+				return nil, err
+			}
+			if rc, ok := body.(io.ReadCloser); ok {
+				return rc, nil
+			}
+			return io.NopCloser(body), nil
+		}
 	}
 
 	if request.Header == nil {
@@ -128,36 +259,24 @@ func (r *Request) Do() (*http.Response, error) {
 		request.Header[k] = v
 	}
 
+	return request, nil
+}
+
+func (r *Request) runPreHooks(request *http.Request) error {
 	for _, hook := range r.preHook {
 		if err := hook(request); err != nil {
-			return nil, err
-		}
-	}
-
-	response, err := r.client.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	body := response.Body
-	response.Body = readCloser{
-		read: body.Read,
-		close: func() error {
-			_, err := io.Copy(io.Discard, body)
-			if err1 := body.Close(); err == nil {
-				err = err1
+			if request.Body != nil {
+				request.Body.Close()
 			}
 			return err
-		},
-	}
-
-	for _, hook := range r.postHook {
-		if err := hook(response); err != nil {
-			response.Body.Close()
-			return nil, err
 		}
 	}
+	return nil
+}
 
-	return response, nil
+// リクエストボディを再送できるかどうか。
+func (r *Request) replayable() bool {
+	return r.body == nil || r.getBody != nil
 }
 
 // リクエストを実行してレスポンスボディをひらく。
@@ -167,7 +286,7 @@ func (r *Request) Open() (io.ReadCloser, error) {
 		return nil, err
 	}
 	if response.StatusCode >= 400 {
-		err := responseError(response, r.errBodyLimit, statusCodeError(response.StatusCode))
+		err := r.responseError(response, statusCodeError(response.StatusCode))
 		response.Body.Close()
 		return nil, err
 	}
@@ -212,6 +331,19 @@ func (r *Request) FetchJSON(v any) error {
 	return err
 }
 
+// 再送できないリクエストボディのかわりにするプレースホルダー。
+type unreplayableBody struct{}
+
+func (unreplayableBody) Read([]byte) (int, error) {
+	return 0, ErrBodyNotReplayable
+}
+
+func (unreplayableBody) Close() error {
+	return nil
+}
+
+type doFunc func(*http.Request) (*http.Response, error)
+
 type readCloser struct {
 	read  func([]byte) (int, error)
 	close func() error
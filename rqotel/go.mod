@@ -0,0 +1,15 @@
+module github.com/thamaji/rq/rqotel
+
+go 1.21
+
+require (
+	github.com/thamaji/rq v0.0.0-20261018111051-06e545b74e18
+	go.opentelemetry.io/otel v1.29.0
+	go.opentelemetry.io/otel/metric v1.29.0
+	go.opentelemetry.io/otel/trace v1.29.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+)
@@ -0,0 +1,295 @@
+package rqotel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thamaji/rq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/thamaji/rq/rqotel"
+
+// リクエストをOpenTelemetryで計装する。
+// Doごとにクライアントスパンをはじめ、traceparentヘッダを送信し、所要時間とサイズをヒストグラムに記録する。
+// 何もセットしなければ、otelパッケージのグローバルなTracerProviderとMeterProviderと、W3C Trace Contextを使う。
+func Instrument() InstrumentOption {
+	return InstrumentOption{&instrumentation{}}
+}
+
+type InstrumentOption struct {
+	instrumentation *instrumentation
+}
+
+func (option InstrumentOption) Apply(r *rq.Request) {
+	i := option.instrumentation
+	i.init()
+	r.With(rq.AroundHook(i.around), rq.PreHook(i.preHook))
+}
+
+// スパンをつくるTracerProviderをセットする。
+func (option InstrumentOption) TracerProvider(provider trace.TracerProvider) InstrumentOption {
+	i := option.instrumentation.clone()
+	i.tracerProvider = provider
+	return InstrumentOption{i}
+}
+
+// ヒストグラムをつくるMeterProviderをセットする。
+func (option InstrumentOption) MeterProvider(provider metric.MeterProvider) InstrumentOption {
+	i := option.instrumentation.clone()
+	i.meterProvider = provider
+	return InstrumentOption{i}
+}
+
+// ヘッダにトレースコンテキストを書き込むTextMapPropagatorをセットする。
+func (option InstrumentOption) Propagator(propagator propagation.TextMapPropagator) InstrumentOption {
+	i := option.instrumentation.clone()
+	i.propagator = propagator
+	return InstrumentOption{i}
+}
+
+type instrumentation struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	propagator     propagation.TextMapPropagator
+
+	once         sync.Once
+	tracer       trace.Tracer
+	duration     metric.Float64Histogram
+	requestSize  metric.Int64Histogram
+	responseSize metric.Int64Histogram
+}
+
+func (i *instrumentation) clone() *instrumentation {
+	return &instrumentation{
+		tracerProvider: i.tracerProvider,
+		meterProvider:  i.meterProvider,
+		propagator:     i.propagator,
+	}
+}
+
+func (i *instrumentation) init() {
+	i.once.Do(func() {
+		if i.tracerProvider == nil {
+			i.tracerProvider = otel.GetTracerProvider()
+		}
+		if i.meterProvider == nil {
+			i.meterProvider = otel.GetMeterProvider()
+		}
+		if i.propagator == nil {
+			i.propagator = propagation.TraceContext{}
+		}
+
+		i.tracer = i.tracerProvider.Tracer(instrumentationName)
+
+		meter := i.meterProvider.Meter(instrumentationName)
+		var err error
+		i.duration, err = meter.Float64Histogram("http.client.request.duration",
+			metric.WithDescription("Duration of HTTP client requests."),
+			metric.WithUnit("s"),
+			metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10),
+		)
+		if err != nil {
+			otel.Handle(err)
+		}
+		i.requestSize, err = meter.Int64Histogram("http.client.request.body.size",
+			metric.WithDescription("Size of HTTP client request bodies."),
+			metric.WithUnit("By"),
+		)
+		if err != nil {
+			otel.Handle(err)
+		}
+		i.responseSize, err = meter.Int64Histogram("http.client.response.body.size",
+			metric.WithDescription("Size of HTTP client response bodies."),
+			metric.WithUnit("By"),
+		)
+		if err != nil {
+			otel.Handle(err)
+		}
+	})
+}
+
+type exchangeKey struct{}
+
+// Doひとつぶんの計装の状態。
+type exchange struct {
+	span        trace.Span
+	started     time.Time
+	attrs       []attribute.KeyValue
+	requestSize int64
+}
+
+func (i *instrumentation) around(ctx context.Context, next func(context.Context) (*http.Response, error)) (*http.Response, error) {
+	ctx, span := i.tracer.Start(ctx, "HTTP", trace.WithSpanKind(trace.SpanKindClient))
+	x := &exchange{span: span, started: time.Now()}
+
+	response, err := next(context.WithValue(ctx, exchangeKey{}, x))
+	if err != nil {
+		errorType := errorType(err)
+		span.SetAttributes(semconv.ErrorTypeKey.String(errorType))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		i.record(ctx, x, -1, semconv.ErrorTypeKey.String(errorType))
+		span.End()
+		return nil, err
+	}
+
+	attrs := []attribute.KeyValue{semconv.HTTPResponseStatusCode(response.StatusCode)}
+	if response.ProtoMajor > 0 {
+		attrs = append(attrs, semconv.NetworkProtocolVersion(protocolVersion(response.ProtoMajor, response.ProtoMinor)))
+	}
+	if response.StatusCode >= 400 {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(strconv.Itoa(response.StatusCode)))
+		span.SetStatus(codes.Error, "")
+	}
+	span.SetAttributes(attrs...)
+
+	body := &responseBody{body: response.Body}
+	body.finish = func() {
+		body.once.Do(func() {
+			i.record(ctx, x, body.size, attrs...)
+			span.End()
+		})
+	}
+	response.Body = body
+	return response, nil
+}
+
+// 試行ごとにスパンの属性をセットして、トレースコンテキストをヘッダに書き込む。
+func (i *instrumentation) preHook(request *http.Request) error {
+	x, ok := request.Context().Value(exchangeKey{}).(*exchange)
+	if !ok {
+		return nil
+	}
+
+	method := request.Method
+	if !knownMethods[method] {
+		method = semconv.HTTPRequestMethodOther.Value.AsString()
+	}
+	x.attrs = []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(method),
+		semconv.ServerAddress(request.URL.Hostname()),
+		semconv.ServerPort(port(request.URL)),
+		semconv.URLScheme(request.URL.Scheme),
+	}
+	x.requestSize = request.ContentLength
+
+	name := method
+	if method == semconv.HTTPRequestMethodOther.Value.AsString() {
+		name = "HTTP"
+	}
+	x.span.SetName(name)
+	x.span.SetAttributes(x.attrs...)
+	x.span.SetAttributes(semconv.URLFull(stripCredentials(request.URL)))
+	if userAgent := request.UserAgent(); userAgent != "" {
+		x.span.SetAttributes(semconv.UserAgentOriginal(userAgent))
+	}
+	if attempt := rq.Attempt(request.Context()); attempt > 1 {
+		x.span.SetAttributes(semconv.HTTPRequestResendCount(attempt - 1))
+		x.span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt)))
+	}
+
+	i.propagator.Inject(request.Context(), propagation.HeaderCarrier(request.Header))
+	return nil
+}
+
+// 所要時間とサイズを記録する。responseSizeが負のときはレスポンスサイズを記録しない。
+func (i *instrumentation) record(ctx context.Context, x *exchange, responseSize int64, attrs ...attribute.KeyValue) {
+	set := metric.WithAttributeSet(attribute.NewSet(append(append([]attribute.KeyValue{}, x.attrs...), attrs...)...))
+	i.duration.Record(ctx, time.Since(x.started).Seconds(), set)
+	if x.requestSize >= 0 {
+		i.requestSize.Record(ctx, x.requestSize, set)
+	}
+	if responseSize >= 0 {
+		i.responseSize.Record(ctx, responseSize, set)
+	}
+}
+
+// 読み込んだバイト数を数えて、読み終えるか閉じたときにfinishを呼ぶ。
+type responseBody struct {
+	body   io.ReadCloser
+	size   int64
+	once   sync.Once
+	finish func()
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.size += int64(n)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	err := b.body.Close()
+	b.finish()
+	return err
+}
+
+var knownMethods = map[string]bool{
+	http.MethodConnect: true,
+	http.MethodDelete:  true,
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPatch:   true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodTrace:   true,
+}
+
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "context.Canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "context.DeadlineExceeded"
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+func protocolVersion(major int, minor int) string {
+	if minor == 0 && major >= 2 {
+		return strconv.Itoa(major)
+	}
+	return strconv.Itoa(major) + "." + strconv.Itoa(minor)
+}
+
+func port(u *url.URL) int {
+	if p, err := strconv.Atoi(u.Port()); err == nil {
+		return p
+	}
+	switch u.Scheme {
+	case "http":
+		return 80
+	case "https":
+		return 443
+	}
+	return 0
+}
+
+// URLからユーザー名とパスワードを取り除く。
+func stripCredentials(u *url.URL) string {
+	v := *u
+	v.User = nil
+	return v.String()
+}
@@ -0,0 +1,413 @@
+package rqtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/thamaji/rq"
+)
+
+// 記録したリクエストとレスポンスの組。
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode   int         `json:"status_code"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+type cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// 置き換えたシークレットの値。
+const Redacted = "REDACTED"
+
+// リクエストとレスポンスをカセットファイルに記録するRecorderをつくる。
+// カセットファイルはテストの終了時に保存される。
+func Record(t testing.TB, path string) *Recorder {
+	t.Helper()
+	rec := newRecorder(t, path, true)
+	t.Cleanup(func() {
+		if err := rec.save(); err != nil {
+			t.Errorf("rqtest: save cassette %s: %v", path, err)
+		}
+	})
+	return rec
+}
+
+// カセットファイルからレスポンスを再生するRecorderをつくる。
+// 一致するリクエストがカセットにないときはテストを失敗させ、エラーを返す。
+func Replay(t testing.TB, path string) *Recorder {
+	t.Helper()
+	rec := newRecorder(t, path, false)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("rqtest: load cassette %s: %v", path, err)
+	}
+	c := cassette{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatalf("rqtest: load cassette %s: %v", path, err)
+	}
+	rec.interactions = c.Interactions
+	rec.used = make([]bool, len(c.Interactions))
+	return rec
+}
+
+// カセットファイルがあれば再生し、なければ記録するRecorderをつくる。
+func New(t testing.TB, path string) *Recorder {
+	t.Helper()
+	if _, err := os.Stat(path); err == nil {
+		return Replay(t, path)
+	}
+	return Record(t, path)
+}
+
+// リクエストとレスポンスを記録・再生するhttp.RoundTripper。
+type Recorder struct {
+	t         testing.TB
+	path      string
+	recording bool
+
+	transport     http.RoundTripper
+	redactHeaders []string
+	redactQueries []string
+	matchBody     bool
+	matchHeaders  []string
+	matchers      []func(*http.Request, []byte, Interaction) bool
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+func newRecorder(t testing.TB, path string, recording bool) *Recorder {
+	return &Recorder{
+		t:             t,
+		path:          path,
+		recording:     recording,
+		transport:     http.DefaultTransport,
+		redactHeaders: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+	}
+}
+
+// 記録するときに実際にリクエストを送るhttp.RoundTripperをセットする。
+func (rec *Recorder) Transport(transport http.RoundTripper) *Recorder {
+	rec.transport = transport
+	return rec
+}
+
+// 記録するときに値を伏せるヘッダを追加する。
+// Authorization, Proxy-Authorization, Cookie, Set-Cookieは最初から伏せる。
+func (rec *Recorder) RedactHeader(key ...string) *Recorder {
+	rec.redactHeaders = append(rec.redactHeaders, key...)
+	return rec
+}
+
+// 記録するときに値を伏せるURLクエリを追加する。
+func (rec *Recorder) RedactQuery(key ...string) *Recorder {
+	rec.redactQueries = append(rec.redactQueries, key...)
+	return rec
+}
+
+// 再生するときにリクエストボディも一致させる。JSONのときは意味が同じなら一致とみなす。
+func (rec *Recorder) MatchBody() *Recorder {
+	rec.matchBody = true
+	return rec
+}
+
+// 再生するときにヘッダも一致させる。
+func (rec *Recorder) MatchHeader(key ...string) *Recorder {
+	rec.matchHeaders = append(rec.matchHeaders, key...)
+	return rec
+}
+
+// 再生するときに使う独自の条件を追加する。
+func (rec *Recorder) Matcher(matcher func(request *http.Request, body []byte, interaction Interaction) bool) *Recorder {
+	rec.matchers = append(rec.matchers, matcher)
+	return rec
+}
+
+// Recorderを使うHTTPクライアントを返す。
+func (rec *Recorder) Client() *http.Client {
+	return &http.Client{Transport: rec}
+}
+
+// Recorderを使うHTTPクライアントをセットするオプションを返す。
+func (rec *Recorder) Option() rq.Option {
+	return rq.HTTPClient(rec.Client())
+}
+
+func (rec *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.recording {
+		return rec.record(request, body)
+	}
+	return rec.replay(request, body)
+}
+
+func (rec *Recorder) record(request *http.Request, body []byte) (*http.Response, error) {
+	outgoing := request.Clone(request.Context())
+	if request.Body != nil {
+		outgoing.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	response, err := rec.transport.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err1 := response.Body.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method: request.Method,
+			URL:    request.URL.String(),
+			Header: request.Header.Clone(),
+		},
+		Response: RecordedResponse{
+			StatusCode: response.StatusCode,
+			Header:     response.Header.Clone(),
+		},
+	}
+	interaction.Request.Body, interaction.Request.BodyEncoding = encodeBody(body)
+	interaction.Response.Body, interaction.Response.BodyEncoding = encodeBody(responseBody)
+
+	rec.mu.Lock()
+	rec.interactions = append(rec.interactions, interaction)
+	rec.mu.Unlock()
+
+	return response, nil
+}
+
+func (rec *Recorder) replay(request *http.Request, body []byte) (*http.Response, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	found := -1
+	for i, interaction := range rec.interactions {
+		if !rec.match(request, body, interaction) {
+			continue
+		}
+		found = i
+		if !rec.used[i] {
+			break
+		}
+	}
+
+	if found < 0 {
+		err := fmt.Errorf("rqtest: no interaction in %s matched %s %s", rec.path, request.Method, request.URL.String())
+		rec.t.Error(err)
+		return nil, err
+	}
+	rec.used[found] = true
+
+	recorded := rec.interactions[found].Response
+	responseBody, err := decodeBody(recorded.Body, recorded.BodyEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(responseBody)),
+		ContentLength: int64(len(responseBody)),
+		Request:       request,
+	}, nil
+}
+
+func (rec *Recorder) match(request *http.Request, body []byte, interaction Interaction) bool {
+	if request.Method != interaction.Request.Method {
+		return false
+	}
+
+	u, err := url.Parse(interaction.Request.URL)
+	if err != nil {
+		return false
+	}
+	if request.URL.Scheme != u.Scheme || request.URL.Host != u.Host || request.URL.Path != u.Path {
+		return false
+	}
+	if !rec.matchQuery(request.URL.Query(), u.Query()) {
+		return false
+	}
+
+	for _, key := range rec.matchHeaders {
+		if !reflect.DeepEqual(request.Header.Values(key), interaction.Request.Header.Values(key)) {
+			return false
+		}
+	}
+
+	if rec.matchBody {
+		recorded, err := decodeBody(interaction.Request.Body, interaction.Request.BodyEncoding)
+		if err != nil || !equalBody(body, recorded) {
+			return false
+		}
+	}
+
+	for _, matcher := range rec.matchers {
+		if !matcher(request, body, interaction) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rec *Recorder) matchQuery(actual url.Values, recorded url.Values) bool {
+	if len(actual) != len(recorded) {
+		return false
+	}
+	for key, values := range recorded {
+		if contains(rec.redactQueries, key) {
+			if _, ok := actual[key]; !ok {
+				return false
+			}
+			continue
+		}
+		if !reflect.DeepEqual(actual[key], values) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rec *Recorder) save() error {
+	rec.mu.Lock()
+	interactions := make([]Interaction, len(rec.interactions))
+	copy(interactions, rec.interactions)
+	rec.mu.Unlock()
+
+	for i := range interactions {
+		interactions[i] = rec.redact(interactions[i])
+	}
+
+	buf := bytes.NewBuffer(nil)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(cassette{Interactions: interactions}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(rec.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(rec.path, buf.Bytes(), 0644)
+}
+
+func (rec *Recorder) redact(interaction Interaction) Interaction {
+	for _, key := range rec.redactHeaders {
+		if _, ok := interaction.Request.Header[http.CanonicalHeaderKey(key)]; ok {
+			interaction.Request.Header.Set(key, Redacted)
+		}
+		if _, ok := interaction.Response.Header[http.CanonicalHeaderKey(key)]; ok {
+			interaction.Response.Header.Set(key, Redacted)
+		}
+	}
+
+	if len(rec.redactQueries) > 0 {
+		if u, err := url.Parse(interaction.Request.URL); err == nil {
+			query := u.Query()
+			for _, key := range rec.redactQueries {
+				if _, ok := query[key]; ok {
+					query.Set(key, Redacted)
+				}
+			}
+			u.RawQuery = query.Encode()
+			interaction.Request.URL = u.String()
+		}
+	}
+
+	return interaction
+}
+
+func readRequestBody(request *http.Request) ([]byte, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(request.Body)
+	if err1 := request.Body.Close(); err == nil {
+		err = err1
+	}
+	return body, err
+}
+
+func encodeBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func decodeBody(body string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(body), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return nil, errors.New("rqtest: unknown body encoding: " + encoding)
+}
+
+func equalBody(a []byte, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func contains(s []string, v string) bool {
+	for i := range s {
+		if s[i] == v {
+			return true
+		}
+	}
+	return false
+}
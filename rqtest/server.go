@@ -0,0 +1,268 @@
+package rqtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thamaji/rq"
+)
+
+// 期待するリクエストを宣言して、そのとおりに呼ばれたか検証するモックサーバーをつくる。
+// テストの終了時にすべての期待が満たされたか検証する。
+func NewServer(t testing.TB) *Server {
+	s := &Server{t: t}
+	t.Cleanup(s.Verify)
+	return s
+}
+
+// モックサーバー。http.Handlerとしても使える。
+type Server struct {
+	t testing.TB
+
+	mu           sync.Mutex
+	expectations []*Expectation
+}
+
+// 期待するリクエストを追加する。
+func (s *Server) Expect(method string, path string, matchers ...Matcher) *Expectation {
+	e := &Expectation{
+		method:     method,
+		path:       path,
+		matchers:   matchers,
+		times:      0,
+		statusCode: http.StatusOK,
+		header:     http.Header{},
+	}
+	s.mu.Lock()
+	s.expectations = append(s.expectations, e)
+	s.mu.Unlock()
+	return e
+}
+
+// モックサーバーでリクエストを処理するオプションを返す。
+func (s *Server) Option() rq.Option {
+	return rq.Handler(s)
+}
+
+// すべての期待が満たされたか検証する。
+func (s *Server) Verify() {
+	s.t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.expectations {
+		switch {
+		case e.times == 0 && e.called == 0:
+			s.t.Errorf("rqtest: expected %s was not called", e)
+		case e.times > 0 && e.called != e.times:
+			s.t.Errorf("rqtest: expected %s to be called %d times, but called %d times", e, e.times, e.called)
+		}
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	var found *Expectation
+	var closest *Expectation
+	var closestDiff []string
+	for _, e := range s.expectations {
+		diff := e.diff(request, body)
+		if len(diff) == 0 {
+			if e.times == 0 || e.called < e.times {
+				found = e
+				break
+			}
+			diff = []string{fmt.Sprintf("already called %d times", e.called)}
+		}
+		if closest == nil || len(diff) < len(closestDiff) {
+			closest = e
+			closestDiff = diff
+		}
+	}
+	if found != nil {
+		found.called++
+	}
+	s.mu.Unlock()
+
+	if found == nil {
+		msg := fmt.Sprintf("rqtest: unexpected request %s %s", request.Method, request.URL.RequestURI())
+		if closest != nil {
+			msg += fmt.Sprintf("\nclosest expectation: %s", closest)
+			for _, d := range closestDiff {
+				msg += "\n  - " + d
+			}
+		}
+		s.t.Error(msg)
+		http.Error(w, msg, http.StatusNotImplemented)
+		return
+	}
+
+	for k, v := range found.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(found.statusCode)
+	w.Write(found.body)
+}
+
+// 期待するリクエストとそのレスポンス。
+type Expectation struct {
+	method   string
+	path     string
+	matchers []Matcher
+	times    int
+	called   int
+
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func (e *Expectation) String() string {
+	return e.method + " " + e.path
+}
+
+// 呼ばれる回数をセットする。セットしないときは1回以上呼ばれればよい。
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// 1回だけ呼ばれることを期待する。
+func (e *Expectation) Once() *Expectation {
+	return e.Times(1)
+}
+
+// 返すレスポンスをセットする。
+func (e *Expectation) Respond(statusCode int, options ...ResponseOption) *Expectation {
+	e.statusCode = statusCode
+	for _, option := range options {
+		option(e)
+	}
+	return e
+}
+
+// 期待との差分を返す。
+func (e *Expectation) diff(request *http.Request, body []byte) []string {
+	diff := []string{}
+	if request.Method != e.method {
+		diff = append(diff, fmt.Sprintf("method: expected %q, got %q", e.method, request.Method))
+	}
+	if request.URL.Path != e.path {
+		diff = append(diff, fmt.Sprintf("path: expected %q, got %q", e.path, request.URL.Path))
+	}
+	for _, matcher := range e.matchers {
+		if err := matcher(request, body); err != nil {
+			diff = append(diff, err.Error())
+		}
+	}
+	return diff
+}
+
+// リクエストが期待どおりか調べる。期待どおりでないときは差分を説明するエラーを返す。
+type Matcher func(request *http.Request, body []byte) error
+
+// ヘッダを期待する。
+func MatchHeader(key string, value string) Matcher {
+	return func(request *http.Request, body []byte) error {
+		if v := request.Header.Get(key); v != value {
+			return fmt.Errorf("header %s: expected %q, got %q", key, value, v)
+		}
+		return nil
+	}
+}
+
+// URLクエリを期待する。
+func MatchQuery(key string, value string) Matcher {
+	return func(request *http.Request, body []byte) error {
+		if v := request.URL.Query().Get(key); v != value {
+			return fmt.Errorf("query %s: expected %q, got %q", key, value, v)
+		}
+		return nil
+	}
+}
+
+// リクエストボディを期待する。
+func MatchBody(value string) Matcher {
+	return func(request *http.Request, body []byte) error {
+		if string(body) != value {
+			return fmt.Errorf("body: expected %q, got %q", value, string(body))
+		}
+		return nil
+	}
+}
+
+// JSONのリクエストボディを期待する。意味が同じなら一致とみなす。
+func MatchBodyJSON(value any) Matcher {
+	return func(request *http.Request, body []byte) error {
+		expected, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("body: %v", err)
+		}
+		var x, y any
+		if err := json.Unmarshal(expected, &x); err != nil {
+			return fmt.Errorf("body: %v", err)
+		}
+		if err := json.Unmarshal(body, &y); err != nil || !reflect.DeepEqual(x, y) {
+			return fmt.Errorf("body: expected %s, got %s", expected, strings.TrimSpace(string(body)))
+		}
+		return nil
+	}
+}
+
+// 独自の条件を期待する。
+func MatchFunc(name string, f func(request *http.Request, body []byte) bool) Matcher {
+	return func(request *http.Request, body []byte) error {
+		if !f(request, body) {
+			return fmt.Errorf("%s: not matched", name)
+		}
+		return nil
+	}
+}
+
+// レスポンスのオプション。
+type ResponseOption func(*Expectation)
+
+// レスポンスヘッダをセットする。
+func ResponseHeader(key string, value string) ResponseOption {
+	return func(e *Expectation) {
+		e.header.Add(key, value)
+	}
+}
+
+// レスポンスボディをセットする。
+func ResponseBody(body []byte) ResponseOption {
+	return func(e *Expectation) {
+		e.body = body
+	}
+}
+
+// 文字列のレスポンスボディをセットする。
+func ResponseBodyString(body string) ResponseOption {
+	return ResponseBody([]byte(body))
+}
+
+// JSONのレスポンスボディをセットする。
+func ResponseBodyJSON(body any) ResponseOption {
+	return func(e *Expectation) {
+		buf := bytes.NewBuffer(nil)
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			panic(err)
+		}
+		e.header.Set("Content-Type", "application/json; charset=UTF-8")
+		e.body = buf.Bytes()
+	}
+}
@@ -0,0 +1,180 @@
+package rq
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// リクエストの各段階の所要時間。
+type Timing struct {
+	DNS             time.Duration // 名前解決
+	Connect         time.Duration // TCP接続
+	TLSHandshake    time.Duration // TLSハンドシェイク
+	TimeToFirstByte time.Duration // リクエスト開始からレスポンスの最初の1バイトまで
+	Transfer        time.Duration // レスポンスの最初の1バイトからレスポンスボディを読み終えるまで
+	Total           time.Duration // リクエスト開始からレスポンスボディを読み終えるまで
+	Reused          bool          // 接続を再利用したかどうか
+	RemoteAddr      string        // 接続先のアドレス
+}
+
+func (t Timing) String() string {
+	return fmt.Sprintf("dns=%s connect=%s tls=%s ttfb=%s transfer=%s total=%s reused=%t remote=%s",
+		t.DNS, t.Connect, t.TLSHandshake, t.TimeToFirstByte, t.Transfer, t.Total, t.Reused, t.RemoteAddr)
+}
+
+// リクエストの各段階の所要時間をtimingにセットする。
+// 所要時間はDoごとに記録して、レスポンスボディを読み終えるか閉じたとき、または通信エラーのときにtimingにコピーする。
+// リトライしたときは最後の試行の所要時間になる。ResponseError.Timingにもエラーになった時点の所要時間が入る。
+func Timings(timing *Timing) Option {
+	return OptionFunc(func(r *Request) {
+		r.aroundHook = append(r.aroundHook, func(ctx context.Context, next func(context.Context) (*http.Response, error)) (*http.Response, error) {
+			recorder := &timingRecorder{}
+			response, err := next(context.WithValue(ctx, timingKey{}, recorder))
+			if err != nil {
+				recorder.copyTo(timing)
+				return nil, err
+			}
+
+			once := sync.Once{}
+			finish := func() {
+				once.Do(func() {
+					recorder.copyTo(timing)
+				})
+			}
+			response.Body = &captureBody{body: response.Body, buf: &captureBuffer{}, finish: finish}
+			return response, nil
+		})
+		r.middleware = append(r.middleware, timingMiddleware)
+	})
+}
+
+type timingKey struct{}
+
+// Doひとつぶんの所要時間。
+type timingRecorder struct {
+	mu       sync.Mutex
+	timing   Timing
+	started  time.Time
+	finished bool
+}
+
+// 試行をはじめるときに記録を消す。
+func (t *timingRecorder) reset() {
+	t.mu.Lock()
+	t.timing = Timing{}
+	t.started = time.Now()
+	t.finished = false
+	t.mu.Unlock()
+}
+
+func (t *timingRecorder) set(f func(timing *Timing)) {
+	t.mu.Lock()
+	f(&t.timing)
+	t.mu.Unlock()
+}
+
+// 記録を終える。
+func (t *timingRecorder) finish() {
+	t.mu.Lock()
+	if !t.finished {
+		t.finished = true
+		t.timing.Total = time.Since(t.started)
+		t.timing.Transfer = t.timing.Total - t.timing.TimeToFirstByte
+	}
+	t.mu.Unlock()
+}
+
+// 現時点の所要時間を返す。記録を終えていないときは現時点までをTotalにする。
+func (t *timingRecorder) snapshot() Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := t.timing
+	if !t.finished && !t.started.IsZero() {
+		timing.Total = time.Since(t.started)
+		if timing.TimeToFirstByte > 0 {
+			timing.Transfer = timing.Total - timing.TimeToFirstByte
+		}
+	}
+	return timing
+}
+
+// 同じTimingを共有するリクエストが同時に書き込まないようにする。
+var timingMu sync.Mutex
+
+func (t *timingRecorder) copyTo(timing *Timing) {
+	snapshot := t.snapshot()
+	timingMu.Lock()
+	*timing = snapshot
+	timingMu.Unlock()
+}
+
+func timingMiddleware(next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		t, ok := request.Context().Value(timingKey{}).(*timingRecorder)
+		if !ok {
+			return next(request)
+		}
+
+		t.reset()
+		var dnsStart, connectStart, tlsStart time.Time
+		trace := &httptrace.ClientTrace{
+			DNSStart: func(httptrace.DNSStartInfo) {
+				t.set(func(*Timing) { dnsStart = time.Now() })
+			},
+			DNSDone: func(httptrace.DNSDoneInfo) {
+				t.set(func(timing *Timing) { timing.DNS = time.Since(dnsStart) })
+			},
+			ConnectStart: func(string, string) {
+				t.set(func(*Timing) {
+					if connectStart.IsZero() {
+						connectStart = time.Now()
+					}
+				})
+			},
+			ConnectDone: func(network string, addr string, err error) {
+				t.set(func(timing *Timing) {
+					if err == nil && timing.Connect == 0 {
+						timing.Connect = time.Since(connectStart)
+					}
+				})
+			},
+			TLSHandshakeStart: func() {
+				t.set(func(*Timing) { tlsStart = time.Now() })
+			},
+			TLSHandshakeDone: func(tls.ConnectionState, error) {
+				t.set(func(timing *Timing) { timing.TLSHandshake = time.Since(tlsStart) })
+			},
+			GotConn: func(info httptrace.GotConnInfo) {
+				t.set(func(timing *Timing) {
+					timing.Reused = info.Reused
+					if info.Conn != nil {
+						timing.RemoteAddr = info.Conn.RemoteAddr().String()
+					}
+				})
+			},
+			GotFirstResponseByte: func() {
+				t.set(func(timing *Timing) { timing.TimeToFirstByte = time.Since(t.started) })
+			},
+		}
+
+		request = request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+		response, err := next(request)
+		if err != nil {
+			t.finish()
+			return nil, err
+		}
+
+		t.set(func(timing *Timing) {
+			if timing.TimeToFirstByte == 0 {
+				timing.TimeToFirstByte = time.Since(t.started)
+			}
+		})
+		response.Body = &captureBody{body: response.Body, buf: &captureBuffer{}, finish: t.finish}
+		return response, nil
+	}
+}
@@ -2,38 +2,157 @@ package rq
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // リクエスト、レスポンスの内容を出力する。
-func Verbose(w io.Writer) Option {
-	return OptionFunc(func(r *Request) {
-		r.With(
-			PreHook(func(request *http.Request) error {
-				fmt.Fprintln(w, ">", request.Method, request.URL.Path, request.Proto)
-				fmt.Fprintln(w, ">", "Host:", request.Host)
-				for k := range request.Header {
-					fmt.Fprintln(w, ">", k+":", request.Header.Get(k))
-				}
-				return nil
-			}),
-			PostHook(func(response *http.Response) error {
-				body, err := io.ReadAll(response.Body)
-				if err != nil {
-					return err
-				}
-				response.Body = io.NopCloser(bytes.NewReader(body))
-
-				fmt.Fprintln(w, "<", response.Proto, response.StatusCode)
-				for k := range response.Header {
-					fmt.Fprintln(w, "<", k+":", response.Header.Get(k))
-				}
-				fmt.Fprintln(w, "<")
-				fmt.Fprintln(w, string(body))
-				return nil
-			}),
-		)
+// レスポンスボディはメモリにためずに、読み込まれた内容を最大limitバイトまで出力する。
+func Verbose(w io.Writer) VerboseOption {
+	return VerboseOption{w: w, limit: 4096}
+}
+
+type VerboseOption struct {
+	w     io.Writer
+	limit int64
+}
+
+func (option VerboseOption) Apply(r *Request) {
+	r.middleware = append(r.middleware, func(next doFunc) doFunc {
+		return option.middleware(r.redactor, next)
 	})
 }
+
+// 出力するリクエストボディとレスポンスボディの最大バイト数をセットする。
+func (option VerboseOption) Limit(limit int64) VerboseOption {
+	option.limit = limit
+	return option
+}
+
+func (option VerboseOption) middleware(redactor *redactor, next doFunc) doFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		var requestBody *captureBuffer
+		if request.Body != nil && request.Body != http.NoBody {
+			requestBody = &captureBuffer{limit: option.limit}
+			request.Body = teeReadCloser{request.Body, requestBody}
+		}
+
+		started := time.Now()
+		response, err := next(request)
+		waited := time.Since(started)
+
+		buf := bytes.NewBuffer(nil)
+		fmt.Fprintln(buf, ">", request.Method, redactor.url(requestURI(request.URL)), request.Proto)
+		fmt.Fprintln(buf, ">", "Host:", request.Host)
+		writeHeader(buf, ">", redactor.header(request.Header))
+		if requestBody != nil {
+			fmt.Fprintln(buf, ">")
+			option.writeBody(buf, redactor, request.Header.Get("Content-Type"), requestBody)
+		}
+
+		if err != nil {
+			fmt.Fprintln(buf, "*", "error:", err.Error())
+			fmt.Fprintln(buf, "*", "time:", waited)
+			option.w.Write(buf.Bytes())
+			return nil, err
+		}
+
+		fmt.Fprintln(buf, "<", response.Proto, response.Status)
+		writeHeader(buf, "<", redactor.header(response.Header))
+		option.w.Write(buf.Bytes())
+
+		responseBody := &captureBuffer{limit: option.limit}
+		once := sync.Once{}
+		finish := func() {
+			once.Do(func() {
+				buf := bytes.NewBuffer(nil)
+				fmt.Fprintln(buf, "<")
+				option.writeBody(buf, redactor, response.Header.Get("Content-Type"), responseBody)
+				fmt.Fprintln(buf, "*", "time:", waited, "to headers,", time.Since(started), "total")
+				option.w.Write(buf.Bytes())
+			})
+		}
+		response.Body = &captureBody{body: response.Body, buf: responseBody, finish: finish}
+		return response, nil
+	}
+}
+
+// ボディを出力する。JSONなら整形し、バイナリなら内容を出力しない。
+func (option VerboseOption) writeBody(w io.Writer, redactor *redactor, contentType string, body *captureBuffer) {
+	b := body.buf.Bytes()
+	truncated := body.size - int64(len(b))
+
+	text := b
+	if truncated > 0 {
+		text = trimIncompleteRune(b)
+	}
+	if !utf8.Valid(text) || bytes.IndexByte(text, 0) >= 0 {
+		fmt.Fprintf(w, "[binary data, %d bytes]\n", body.size)
+		return
+	}
+
+	text = redactor.body(contentType, text)
+	if truncated == 0 {
+		if isJSON(contentType) {
+			indented := bytes.NewBuffer(nil)
+			if err := json.Indent(indented, text, "", "  "); err == nil {
+				text = indented.Bytes()
+			}
+		}
+	}
+
+	w.Write(text)
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		fmt.Fprintln(w)
+	}
+	if truncated > 0 {
+		fmt.Fprintf(w, "[%d bytes truncated]\n", truncated)
+	}
+}
+
+// パスとクエリだけのURLを返す。
+func requestURI(u *url.URL) *url.URL {
+	v := &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}
+	if v.Path == "" {
+		v.Path = "/"
+	}
+	return v
+}
+
+func writeHeader(w io.Writer, prefix string, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintln(w, prefix, k+":", v)
+		}
+	}
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// 途中で切れたUTF-8の文字を取り除く。
+func trimIncompleteRune(b []byte) []byte {
+	for i := 0; i < utf8.UTFMax && len(b) > 0; i++ {
+		if r, _ := utf8.DecodeLastRune(b); r != utf8.RuneError {
+			break
+		}
+		b = b[:len(b)-1]
+	}
+	return b
+}